@@ -0,0 +1,60 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tuenti/pouch/pkg/audit"
+)
+
+// File with the key used for digests of files, in the directory of the
+// state, if no other one is configured
+const DefaultAuditDigestKeyFile = "audit.key"
+
+func (p *pouch) Auditor(a audit.Auditor) {
+	p.auditor = a
+}
+
+func (p *pouch) AuditDigestKey(key []byte) {
+	p.auditDigestKey = key
+}
+
+func (p *pouch) audit(e audit.Event) {
+	if p.auditor != nil {
+		p.auditor.Audit(e)
+	}
+}
+
+// auditFile records a written file, identified by the digest of its
+// content if there is a key for digests, content itself is never recorded
+func (p *pouch) auditFile(path string, content []byte, mode os.FileMode, err error) {
+	event := audit.Event{
+		Type: audit.FileEvent,
+		Path: path,
+		Mode: fmt.Sprintf("%#o", mode.Perm()),
+	}
+	if err == nil {
+		event.Size = len(content)
+		if p.auditDigestKey != nil {
+			event.Digest = audit.Digest(p.auditDigestKey, content)
+		}
+	}
+	event.SetError(err)
+	p.audit(event)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+
+	"github.com/tuenti/pouch/pkg/audit"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingAuditor struct {
+	sync.Mutex
+	events []audit.Event
+}
+
+func (a *recordingAuditor) Audit(e audit.Event) {
+	a.Lock()
+	defer a.Unlock()
+	a.events = append(a.events, e)
+}
+
+// find returns the events of a type
+func (a *recordingAuditor) find(eventType string) []audit.Event {
+	a.Lock()
+	defer a.Unlock()
+	var events []audit.Event
+	for _, e := range a.events {
+		if e.Type == eventType {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+func TestAudit(t *testing.T) {
+	v := &DummyVault{
+		T:             t,
+		Token:         "token",
+		ExpectedToken: "token",
+		Responses: map[string]*api.Secret{
+			"GET/v1/foo": &api.Secret{
+				LeaseDuration: 3600,
+				Data:          map[string]interface{}{"foo": "secretfoo"},
+			},
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/foo", HTTPMethod: "GET"},
+	}
+	fooPath := path.Join(tmpdir, "foo")
+	dirPath := path.Join(tmpdir, "dir")
+	files := []FileConfig{
+		{Path: fooPath, Mode: 0640, Template: `{{ secret "foo" "foo" }}`, Notify: []string{"broken"}},
+		{Directory: dirPath, Secret: "foo"},
+	}
+	notifiers := map[string]NotifierConfig{
+		"broken": {Command: "exit 1"},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, v, secrets, files, notifiers)
+	auditor := &recordingAuditor{}
+	key := []byte("0123456789abcdef0123456789abcdef")
+	p.Auditor(auditor)
+	p.AuditDigestKey(key)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, p.Run(ctx))
+
+	secretEvents := auditor.find(audit.SecretEvent)
+	if assert.Len(t, secretEvents, 1) {
+		e := secretEvents[0]
+		assert.Equal(t, "foo", e.Secret)
+		assert.Equal(t, "GET", e.Method)
+		assert.Equal(t, "/v1/foo", e.Path)
+		assert.Equal(t, 3600, e.LeaseDuration)
+		assert.Equal(t, audit.Success, e.Result)
+	}
+
+	digest := audit.Digest(key, []byte("secretfoo"))
+	fileEvents := auditor.find(audit.FileEvent)
+	if assert.Len(t, fileEvents, 2) {
+		for _, e := range fileEvents {
+			assert.Equal(t, digest, e.Digest)
+			assert.Equal(t, len("secretfoo"), e.Size)
+			assert.Equal(t, audit.Success, e.Result)
+		}
+		paths := []string{fileEvents[0].Path, fileEvents[1].Path}
+		assert.Contains(t, paths, fooPath)
+		assert.Contains(t, paths, path.Join(dirPath, "foo"))
+	}
+
+	notifierEvents := auditor.find(audit.NotifierEvent)
+	if assert.Len(t, notifierEvents, 1) {
+		e := notifierEvents[0]
+		assert.Equal(t, "broken", e.Notifier)
+		assert.Equal(t, []string{fooPath}, e.Files)
+		assert.Equal(t, audit.Failure, e.Result)
+		assert.Contains(t, e.Error, "exit status 1")
+	}
+
+	d, err := json.Marshal(auditor.events)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(d), "secretfoo", "Audit events shouldn't contain secret values")
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -71,7 +72,7 @@ func main() {
 		}
 	}
 
-	err := v.Login()
+	err := v.Login(context.Background())
 	if err != nil {
 		fmt.Printf("Couldn't login to vault with provided credentials: %s\n", err)
 		os.Exit(-1)
@@ -21,9 +21,20 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/audit"
+	"github.com/tuenti/pouch/pkg/control"
+	"github.com/tuenti/pouch/pkg/logging"
+	"github.com/tuenti/pouch/pkg/metrics"
+	"github.com/tuenti/pouch/pkg/openrc"
+	"github.com/tuenti/pouch/pkg/runit"
+	"github.com/tuenti/pouch/pkg/s6"
+	"github.com/tuenti/pouch/pkg/supervisord"
 	"github.com/tuenti/pouch/pkg/systemd"
 	"github.com/tuenti/pouch/pkg/vault"
 )
@@ -39,48 +50,195 @@ func main() {
 	flag.BoolVar(&showVersion, "version", false, "Show version")
 	flag.Parse()
 
+	// Logs of other packages go through the logger too, so they are
+	// formatted and redacted in the same way
+	log.SetFlags(0)
+	log.SetOutput(logging.Default.Writer(logging.InfoLevel))
+
 	if showVersion {
 		fmt.Println(version)
 		os.Exit(0)
 	}
 
+	if flag.Arg(0) == "generate-units" {
+		err := generateUnits(pouchfilePath, flag.Args()[1:])
+		if err != nil {
+			logging.Fatalf("%v", err)
+		}
+		return
+	}
+
+	signals := handleSignals()
+	for {
+		err := run(pouchfilePath, signals)
+		if err != nil {
+			logging.Fatalf("%v", err)
+		}
+		if !signals.finish() {
+			break
+		}
+		logging.Infof("Reloading configuration from %s", pouchfilePath)
+	}
+}
+
+// run runs pouch till it finishes, or till a reload is requested with
+// a SIGHUP
+func run(pouchfilePath string, signals *signalHandler) error {
 	pouchfile, err := pouch.LoadPouchfile(pouchfilePath)
 	if err != nil {
-		log.Fatalf("Couldn't load Pouchfile: %v", err)
+		return fmt.Errorf("Couldn't load Pouchfile: %v", err)
+	}
+
+	level, err := logging.ParseLevel(pouchfile.Log.Level)
+	if err != nil {
+		return err
+	}
+	logging.Default.SetLevel(level)
+	err = logging.Default.SetFormat(pouchfile.Log.Format)
+	if err != nil {
+		return err
 	}
 
 	state, err := pouch.LoadState(pouchfile.StatePath)
 	if err == nil {
-		log.Printf("Using state stored in %s", state.Path)
+		logging.Infof("Using state stored in %s", state.Path)
 		pouchfile.Vault.Token = state.Token
 	} else {
-		log.Printf("Couldn't load state: %s, starting from scratch", err)
+		logging.Warnf("Couldn't load state: %s, starting from scratch", err)
 		state = pouch.NewState(pouchfile.StatePath)
 	}
 
+	var auditor audit.Auditor
+	var auditDigestKey []byte
+	if pouchfile.Audit != nil {
+		auditLog, err := openAuditLog(pouchfile.Audit)
+		if err != nil {
+			return fmt.Errorf("Couldn't open audit log: %v", err)
+		}
+		defer auditLog.Close()
+		auditor = auditLog
+		pouchfile.Vault.Auditor = auditor
+
+		keyFile := pouchfile.Audit.DigestKeyFile
+		if keyFile == "" {
+			keyFile = filepath.Join(filepath.Dir(state.Path), pouch.DefaultAuditDigestKeyFile)
+		}
+		auditDigestKey, err = audit.LoadDigestKey(keyFile)
+		if err != nil {
+			return fmt.Errorf("Couldn't load audit digest key: %v", err)
+		}
+	}
+
 	vault := vault.New(pouchfile.Vault)
 
 	p := pouch.NewPouch(state, vault, pouchfile.Secrets, pouchfile.Files, pouchfile.Notifiers)
+	p.StrictTemplates(pouchfile.StrictTemplates)
+	p.OnExpiryWarning(pouchfile.OnExpiryWarning)
+	p.Auditor(auditor)
+	p.AuditDigestKey(auditDigestKey)
 
 	systemd := systemd.New(pouchfile.Systemd.Configurer())
-	if systemd.IsAvailable() {
-		p.ServiceReloader(systemd)
-		if systemd.CanNotify() {
-			p.AddStatusNotifier(systemd)
-		}
+	systemdAvailable := systemd.IsAvailable()
+	if systemdAvailable && systemd.CanNotify() {
+		p.AddStatusNotifier(systemd)
 	}
 	defer systemd.Close()
 
+	switch pouchfile.ServiceManager {
+	case "", "systemd":
+		if systemdAvailable {
+			p.ServiceReloader(systemd)
+		}
+	case "openrc":
+		p.ServiceReloader(openrc.New())
+	case "s6":
+		p.ServiceReloader(s6.New(pouchfile.S6.ScanDir))
+	case "runit":
+		p.ServiceReloader(runit.New(pouchfile.Runit.ServiceDir))
+	case "supervisord":
+		p.ServiceReloader(supervisord.New(pouchfile.Supervisord.Socket))
+	default:
+		return fmt.Errorf("Unknown service manager: %s", pouchfile.ServiceManager)
+	}
+
+	if pouchfile.ShutdownTimeout != "" {
+		timeout, err := time.ParseDuration(pouchfile.ShutdownTimeout)
+		if err != nil {
+			return fmt.Errorf("Incorrect shutdown timeout: %v", err)
+		}
+		p.ShutdownTimeout(timeout)
+	}
+
+	if pouchfile.Metrics != nil {
+		l, err := metrics.Listen(pouchfile.Metrics.Address, pouchfile.Metrics.Socket)
+		if err != nil {
+			return fmt.Errorf("Couldn't listen for metrics: %v", err)
+		}
+		server := &http.Server{Handler: pouch.MetricsHandler(p)}
+		defer server.Close()
+		go server.Serve(l)
+		logging.Infof("Serving metrics on %s", l.Addr())
+	}
+
+	if c := pouchfile.Control; c != nil {
+		l, err := control.Listen(c.Socket, os.FileMode(c.Mode), c.UIDs)
+		if err != nil {
+			return fmt.Errorf("Couldn't listen for control requests: %v", err)
+		}
+		server := &http.Server{Handler: p.ControlHandler()}
+		defer server.Close()
+		go server.Serve(l)
+		logging.Infof("Serving control API on %s", l.Addr())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals.start(p, cancel)
+
 	if path := pouchfile.WrappedSecretIDPath; state.Token == "" && path != "" {
-		log.Printf("Waiting for a wrapped secret ID in %s", path)
-		err = p.Watch(path)
+		logging.Infof("Waiting for a wrapped secret ID in %s", path)
+		err = p.Watch(ctx, path)
+		if err == context.Canceled {
+			return nil
+		}
 		if err != nil {
-			log.Fatalf("Couldn't obtain secret ID from %s: %v", path, err)
+			return fmt.Errorf("Couldn't obtain secret ID from %s: %v", path, err)
 		}
 	}
 
-	err = p.Run(context.Background())
+	err = p.Run(ctx)
 	if err != nil {
-		log.Fatalf("Pouch failed: %v", err)
+		return fmt.Errorf("Pouch failed: %v", err)
+	}
+	return nil
+}
+
+// openAuditLog opens the audit log in a file or in syslog
+func openAuditLog(c *pouch.AuditConfig) (*audit.Log, error) {
+	switch {
+	case c.File != "" && c.Syslog != nil:
+		return nil, fmt.Errorf("only one of file or syslog can be used")
+	case c.Syslog != nil:
+		w, err := audit.NewSyslogWriter(c.Syslog.Network, c.Syslog.Address, c.Syslog.Facility, c.Syslog.Tag)
+		if err != nil {
+			return nil, err
+		}
+		return audit.New(w), nil
+	case c.File != "":
+		maxSize := c.MaxSize
+		if maxSize == 0 {
+			maxSize = audit.DefaultMaxSize
+		}
+		maxBackups := c.MaxBackups
+		if maxBackups == 0 {
+			maxBackups = audit.DefaultMaxBackups
+		}
+		w, err := audit.NewFileWriter(c.File, int64(maxSize)*1024*1024, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		return audit.New(w), nil
 	}
+	return nil, fmt.Errorf("file or syslog needed")
 }
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/logging"
+)
+
+// signalHandler handles signals during the whole life of the process, a
+// SIGHUP reloads the configuration, and a SIGTERM or a SIGINT stops pouch,
+// or makes it exit immediately if it was already stopping
+type signalHandler struct {
+	lock sync.Mutex
+
+	// Current run, not set between runs
+	pouch  pouch.Pouch
+	cancel context.CancelFunc
+
+	reload   bool
+	stopping bool
+}
+
+func handleSignals() *signalHandler {
+	h := &signalHandler{}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		for s := range signals {
+			h.handle(s)
+		}
+	}()
+	return h
+}
+
+func (h *signalHandler) handle(s os.Signal) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	switch {
+	case s != syscall.SIGHUP && h.stopping:
+		logging.Fatalf("Received %s while stopping, exiting", s)
+	case s != syscall.SIGHUP:
+		logging.Infof("Received %s, stopping", s)
+		h.stopping = true
+		h.reload = false
+		if h.pouch != nil {
+			h.pouch.NotifyStopping()
+		}
+	case h.stopping:
+		logging.Infof("Received %s while stopping, ignoring it", s)
+		return
+	case h.reload:
+		// Already reloading
+		return
+	default:
+		logging.Infof("Received %s, reloading", s)
+		h.reload = true
+		if h.pouch != nil {
+			h.pouch.NotifyReloading()
+		}
+	}
+	if h.cancel != nil {
+		h.cancel()
+	}
+}
+
+// start sets the current run, it is cancelled at once if a signal was
+// received while preparing it
+func (h *signalHandler) start(p pouch.Pouch, cancel context.CancelFunc) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.pouch = p
+	h.cancel = cancel
+	if h.reload || h.stopping {
+		cancel()
+	}
+}
+
+// finish unsets the current run, and returns true if the configuration
+// has to be reloaded
+func (h *signalHandler) finish() bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	reload := h.reload && !h.stopping
+	h.pouch = nil
+	h.cancel = nil
+	h.reload = false
+	return reload
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/tuenti/pouch"
+)
+
+const (
+	unitFileMode = os.FileMode(0644)
+	unitDirMode  = os.FileMode(0755)
+)
+
+// generateUnits writes systemd drop-ins for the units notified by pouch
+func generateUnits(pouchfilePath string, args []string) error {
+	var outputDir string
+	var options pouch.UnitsOptions
+	var dryRun bool
+
+	flags := flag.NewFlagSet("generate-units", flag.ExitOnError)
+	flags.StringVar(&outputDir, "output-dir", pouch.DefaultUnitsDir, "Directory where units are written")
+	flags.StringVar(&options.PouchUnit, "pouch-unit", pouch.DefaultPouchUnit, "Name of the unit running pouch")
+	flags.BoolVar(&dryRun, "dry-run", false, "Print units instead of writing them")
+	flags.Parse(args)
+
+	pouchfile, err := pouch.LoadPouchfile(pouchfilePath)
+	if err != nil {
+		return fmt.Errorf("Couldn't load Pouchfile: %v", err)
+	}
+
+	units, err := pouch.GenerateUnits(pouchfile, options)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for path := range units {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		path, content := filepath.Join(outputDir, path), units[path]
+		if dryRun {
+			fmt.Printf("# %s\n%s\n", path, content)
+			continue
+		}
+		err := os.MkdirAll(filepath.Dir(path), unitDirMode)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(path, []byte(content), unitFileMode)
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+	}
+	if !dryRun && len(paths) > 0 {
+		fmt.Println("Run 'systemctl daemon-reload' to apply the changes")
+	}
+	return nil
+}
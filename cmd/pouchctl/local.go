@@ -0,0 +1,204 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tuenti/pouch"
+	"github.com/tuenti/pouch/pkg/control"
+)
+
+// Subcommands talking with the control API of a local pouch, they receive
+// a client, the name of the object to act on, and if output has to be JSON
+var localCommands = map[string]struct {
+	usage string
+	run   func(c *control.Client, name string, jsonOutput bool) error
+}{
+	"status":  {"status [-socket <path>] [-json]", localStatus},
+	"refresh": {"refresh [-socket <path>] [-json] <secret>", localRefresh},
+	"render":  {"render [-socket <path>] [-json] <file>", localRender},
+	"notify":  {"notify [-socket <path>] [-json] <notifier>", localNotify},
+}
+
+// runLocal runs a local subcommand, flags are accepted before and after
+// the name of the object
+func runLocal(command string, args []string) error {
+	cmd := localCommands[command]
+
+	var socket string
+	var jsonOutput bool
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.StringVar(&socket, "socket", control.DefaultSocket, "Control socket of pouch")
+	flags.BoolVar(&jsonOutput, "json", false, "Print output as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: pouchctl %s\n", cmd.usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	var name string
+	if command != "status" {
+		name = flags.Arg(0)
+		if name == "" {
+			flags.Usage()
+			os.Exit(2)
+		}
+		flags.Parse(flags.Args()[1:])
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	return cmd.run(control.NewClient(socket), name, jsonOutput)
+}
+
+type statusOutput struct {
+	Status  pouch.Status       `json:"status"`
+	Token   *pouch.TokenInfo   `json:"token,omitempty"`
+	Secrets []pouch.SecretInfo `json:"secrets"`
+	Files   []pouch.FileInfo   `json:"files"`
+}
+
+func localStatus(c *control.Client, _ string, jsonOutput bool) error {
+	var out statusOutput
+	err := c.Get(pouch.ControlStatusPath, &out.Status)
+	if err != nil {
+		return err
+	}
+	err = c.Get(pouch.ControlSecretsPath, &out.Secrets)
+	if err != nil {
+		return err
+	}
+	err = c.Get(pouch.ControlFilesPath, &out.Files)
+	if err != nil {
+		return err
+	}
+	var token pouch.TokenInfo
+	err = c.Get(pouch.ControlTokenPath, &token)
+	if err != nil {
+		// Not critical, the rest of the status is still useful
+		fmt.Fprintf(os.Stderr, "Couldn't obtain token information: %v\n", err)
+	} else {
+		out.Token = &token
+	}
+
+	if jsonOutput {
+		return printJSON(out)
+	}
+
+	fmt.Printf("Status: %s\n", out.Status)
+	if out.Token != nil {
+		fmt.Printf("Token: TTL %s, expires %s, renewable %t\n",
+			time.Duration(out.Token.TTL)*time.Second, orDash(out.Token.ExpireTime), out.Token.Renewable)
+	}
+	fmt.Println()
+	printSecrets(out.Secrets...)
+	fmt.Println()
+	printFiles(out.Files...)
+	return nil
+}
+
+func localRefresh(c *control.Client, name string, jsonOutput bool) error {
+	var secret pouch.SecretInfo
+	err := c.Post(pouch.ControlRefreshPath, pouch.ControlRequest{Secret: name}, &secret)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(secret)
+	}
+	printSecrets(secret)
+	return nil
+}
+
+func localRender(c *control.Client, name string, jsonOutput bool) error {
+	var file pouch.FileInfo
+	err := c.Post(pouch.ControlRenderPath, pouch.ControlRequest{File: name}, &file)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(file)
+	}
+	printFiles(file)
+	return nil
+}
+
+func localNotify(c *control.Client, name string, jsonOutput bool) error {
+	var notifier pouch.NotifierInfo
+	err := c.Post(pouch.ControlNotifyPath, pouch.ControlRequest{Notifier: name}, &notifier)
+	if err != nil {
+		return err
+	}
+	if jsonOutput {
+		return printJSON(notifier)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NOTIFIER\tLAST RUN\tLAST SUCCESS")
+	fmt.Fprintf(w, "%s\t%s\t%s\n", notifier.Name, formatTime(&notifier.LastRun), formatTime(&notifier.LastSuccess))
+	return w.Flush()
+}
+
+func printJSON(v interface{}) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func printSecrets(secrets ...pouch.SecretInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SECRET\tLAST FETCH\tNEXT UPDATE\tEXPIRY\tFILES")
+	for _, s := range secrets {
+		ttu := formatTime(s.TTU)
+		if !s.AutoUpdate {
+			ttu = "never"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Name, formatTime(&s.LastFetch), ttu, formatTime(s.Expiry), orDash(strings.Join(s.Files, ",")))
+	}
+	w.Flush()
+}
+
+func printFiles(files ...pouch.FileInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tLAST WRITE\tSECRETS\tNOTIFY")
+	for _, f := range files {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Path, formatTime(f.LastWrite), orDash(strings.Join(f.Secrets, ",")), orDash(strings.Join(f.Notify, ",")))
+	}
+	w.Flush()
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
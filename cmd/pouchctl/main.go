@@ -58,6 +58,17 @@ func getSender(destination string) (Sender, error) {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if _, found := localCommands[os.Args[1]]; found {
+			err := runLocal(os.Args[1], os.Args[2:])
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(-1)
+			}
+			return
+		}
+	}
+
 	var destination string
 	var role, roleId, wrappedSecretId, wrapTTL string
 	var address, token string
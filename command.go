@@ -0,0 +1,166 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Environment variables with information about the notification, lists
+// are separated by new lines
+const (
+	NotifierEnvVar = "POUCH_NOTIFIER"
+	FilesEnvVar    = "POUCH_FILES"
+	SecretsEnvVar  = "POUCH_SECRETS"
+)
+
+// Path of commands run with other credentials, that don't inherit the
+// environment of pouch
+const DefaultCommandPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+type CommandNotifier struct {
+	// Command to be run inside a shell
+	Command string
+
+	// Command to be run without shell, used if Command is not set
+	Args []string
+
+	WorkingDir string
+	Env        map[string]string
+
+	// Credentials to run the command with, if not set, the command is
+	// run with the same credentials as pouch
+	Credential *syscall.Credential
+}
+
+func newCommandNotifier(config NotifierConfig) (*CommandNotifier, error) {
+	if config.Command != "" && len(config.Args) > 0 {
+		return nil, fmt.Errorf("only one of command or args can be set")
+	}
+	credential, err := lookupCredential(config.User, config.Group)
+	if err != nil {
+		return nil, err
+	}
+	return &CommandNotifier{
+		Command:    config.Command,
+		Args:       config.Args,
+		WorkingDir: config.WorkingDir,
+		Env:        config.Env,
+		Credential: credential,
+	}, nil
+}
+
+func (n *CommandNotifier) Run(ctx context.Context, notification *Notification) (string, error) {
+	var cmd *exec.Cmd
+	if n.Command != "" {
+		cmd = exec.CommandContext(ctx, "sh", "-c", n.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, n.Args[0], n.Args[1:]...)
+	}
+	cmd.Stdin = nil
+	cmd.Dir = n.WorkingDir
+	cmd.Env = n.environment(notification)
+	if n.Credential != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: n.Credential}
+	}
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+// environment returns the environment for the command, with the variables
+// describing the notification. Commands run with other credentials start
+// from a minimal environment, so they don't receive the one of pouch.
+func (n *CommandNotifier) environment(notification *Notification) []string {
+	var env []string
+	if n.Credential != nil {
+		env = credentialEnvironment(n.Credential)
+	} else {
+		env = os.Environ()
+	}
+	var names []string
+	for name := range n.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, name+"="+n.Env[name])
+	}
+	if notification != nil {
+		env = append(env,
+			NotifierEnvVar+"="+notification.Notifier,
+			FilesEnvVar+"="+strings.Join(notification.Files, "\n"),
+			SecretsEnvVar+"="+strings.Join(notification.Secrets, "\n"),
+		)
+	}
+	return env
+}
+
+// credentialEnvironment returns the minimal environment for commands run
+// with some credentials
+func credentialEnvironment(credential *syscall.Credential) []string {
+	env := []string{"PATH=" + DefaultCommandPath}
+	u, err := user.LookupId(strconv.Itoa(int(credential.Uid)))
+	if err == nil {
+		env = append(env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+	}
+	return env
+}
+
+// lookupCredential finds the ids of an user and group given by name or id.
+// If only the user is given, its primary group is used.
+func lookupCredential(userName, groupName string) (*syscall.Credential, error) {
+	if userName == "" && groupName == "" {
+		return nil, nil
+	}
+	credential := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			u, err = user.LookupId(userName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find user %s", userName)
+		}
+		uid, _ := strconv.Atoi(u.Uid)
+		gid, _ := strconv.Atoi(u.Gid)
+		credential.Uid = uint32(uid)
+		credential.Gid = uint32(gid)
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			g, err = user.LookupGroupId(groupName)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("couldn't find group %s", groupName)
+		}
+		gid, _ := strconv.Atoi(g.Gid)
+		credential.Gid = uint32(gid)
+	}
+	return credential, nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var commandNotifierCases = []struct {
+	Config NotifierConfig
+	Output string
+}{
+	{
+		Config: NotifierConfig{Command: `echo "$POUCH_NOTIFIER: $POUCH_SECRETS"; for f in $POUCH_FILES; do echo $f; done`},
+		Output: "webhook: foo\n/tmp/a\n/tmp/b\n",
+	},
+	{
+		Config: NotifierConfig{Args: []string{"sh", "-c", `echo "$FOO $POUCH_NOTIFIER"`}, Env: map[string]string{"FOO": "bar"}},
+		Output: "bar webhook\n",
+	},
+	{
+		Config: NotifierConfig{Args: []string{"pwd"}, WorkingDir: "/"},
+		Output: "/\n",
+	},
+}
+
+func TestCommandNotifier(t *testing.T) {
+	p := NewPouch(nil, nil, nil, nil, nil).(*pouch)
+	for _, c := range commandNotifierCases {
+		runner, err := p.notifierRunner(c.Config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := runner.Run(context.Background(), testNotification)
+		assert.NoError(t, err)
+		assert.Equal(t, c.Output, out)
+	}
+
+	_, err := p.notifierRunner(NotifierConfig{Command: "true", Args: []string{"true"}})
+	assert.Error(t, err, "Command and args shouldn't be allowed together")
+
+	_, err = p.notifierRunner(NotifierConfig{Command: "true", User: "pouch-test-unknown-user"})
+	assert.Error(t, err, "Unknown users should fail")
+}
+
+func TestCommandNotifierCredential(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("running commands as other user requires root")
+	}
+	dir, err := ioutil.TempDir("", "pouch-test-command")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Chmod(dir, 0777)
+
+	path := filepath.Join(dir, "file")
+	runner, err := newCommandNotifier(NotifierConfig{Args: []string{"touch", path}, User: "nobody"})
+	if err != nil {
+		t.Skip(err)
+	}
+	_, err = runner.Run(context.Background(), testNotification)
+	if assert.NoError(t, err) {
+		info, err := os.Stat(path)
+		if assert.NoError(t, err) {
+			assert.Equal(t, runner.Credential.Uid, info.Sys().(*syscall.Stat_t).Uid)
+		}
+	}
+}
+
+func TestCommandNotifierCredentialEnvironment(t *testing.T) {
+	os.Setenv("POUCH_TEST_DAEMON_VAR", "secret")
+	defer os.Unsetenv("POUCH_TEST_DAEMON_VAR")
+
+	n := &CommandNotifier{Env: map[string]string{"FOO": "bar"}}
+	assert.Contains(t, n.environment(testNotification), "POUCH_TEST_DAEMON_VAR=secret")
+
+	// Other credentials don't inherit the environment of pouch
+	n.Credential = &syscall.Credential{Uid: 0, Gid: 0}
+	env := n.environment(testNotification)
+	assert.NotContains(t, env, "POUCH_TEST_DAEMON_VAR=secret")
+	assert.Contains(t, env, "PATH="+DefaultCommandPath)
+	assert.Contains(t, env, "FOO=bar")
+	assert.Contains(t, env, NotifierEnvVar+"=webhook")
+	assert.Contains(t, env, SecretsEnvVar+"=foo")
+}
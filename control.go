@@ -0,0 +1,317 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/logging"
+	"github.com/tuenti/pouch/pkg/vault"
+)
+
+const (
+	// Time to wait for pouch to accept a control request, it only accepts
+	// them from its main loop, once all secrets have been provisioned
+	ControlQueueTimeout = 10 * time.Second
+
+	ControlStatusPath  = "/v1/status"
+	ControlSecretsPath = "/v1/secrets"
+	ControlFilesPath   = "/v1/files"
+	ControlTokenPath   = "/v1/token"
+	ControlRefreshPath = "/v1/refresh"
+	ControlRenderPath  = "/v1/render"
+	ControlNotifyPath  = "/v1/notify"
+)
+
+// SecretInfo describes a secret, without its data
+type SecretInfo struct {
+	Name          string     `json:"name"`
+	LastFetch     time.Time  `json:"last_fetch"`
+	TTU           *time.Time `json:"ttu,omitempty"`
+	Expiry        *time.Time `json:"expiry,omitempty"`
+	LeaseDuration int        `json:"lease_duration,omitempty"`
+	AutoUpdate    bool       `json:"auto_update"`
+	Files         []string   `json:"files,omitempty"`
+}
+
+// FileInfo describes a file provisioned by pouch
+type FileInfo struct {
+	Path      string     `json:"path"`
+	Secrets   []string   `json:"secrets,omitempty"`
+	Notify    []string   `json:"notify,omitempty"`
+	LastWrite *time.Time `json:"last_write,omitempty"`
+}
+
+// TokenInfo describes the Vault token used by pouch, without the token
+type TokenInfo struct {
+	Accessor   string   `json:"accessor,omitempty"`
+	TTL        int64    `json:"ttl"`
+	ExpireTime string   `json:"expire_time,omitempty"`
+	Renewable  bool     `json:"renewable"`
+	Policies   []string `json:"policies,omitempty"`
+}
+
+// NotifierInfo describes the result of running a notifier
+type NotifierInfo struct {
+	Name string `json:"name"`
+	NotifierState
+}
+
+// ControlRequest is the body of requests to run actions
+type ControlRequest struct {
+	Secret   string `json:"secret,omitempty"`
+	File     string `json:"file,omitempty"`
+	Notifier string `json:"notifier,omitempty"`
+}
+
+// ControlError is the body of failed responses
+type ControlError struct {
+	Error string `json:"error"`
+}
+
+// controlError is an error with the HTTP status code to respond with
+type controlError struct {
+	code    int
+	message string
+}
+
+func (e *controlError) Error() string {
+	return e.message
+}
+
+func newControlError(code int, format string, args ...interface{}) error {
+	return &controlError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// controlRequest is a function to run from the main loop, as it is the
+// only one modifying the state
+type controlRequest struct {
+	run    func(context.Context) (interface{}, error)
+	result chan controlResult
+}
+
+type controlResult struct {
+	value interface{}
+	err   error
+}
+
+// runControl runs a function in the main loop, and waits for its result
+func (p *pouch) runControl(req *http.Request, run func(context.Context) (interface{}, error)) (interface{}, error) {
+	r := &controlRequest{run: run, result: make(chan controlResult, 1)}
+	select {
+	case p.controlRequests <- r:
+	case <-time.After(ControlQueueTimeout):
+		return nil, newControlError(http.StatusServiceUnavailable, "pouch is busy or not ready")
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	select {
+	case result := <-r.result:
+		return result.value, result.err
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+}
+
+func (r *controlRequest) handle(ctx context.Context) {
+	value, err := r.run(ctx)
+	r.result <- controlResult{value: value, err: err}
+}
+
+type controlFunc func(*http.Request) (interface{}, error)
+
+// ControlHandler returns the handler of the control API
+func (p *pouch) ControlHandler() http.Handler {
+	inLoop := func(run func(context.Context) (interface{}, error)) controlFunc {
+		return func(req *http.Request) (interface{}, error) {
+			return p.runControl(req, run)
+		}
+	}
+	action := func(run func(context.Context, ControlRequest) (interface{}, error)) controlFunc {
+		return func(req *http.Request) (interface{}, error) {
+			var r ControlRequest
+			err := json.NewDecoder(req.Body).Decode(&r)
+			if err != nil {
+				return nil, newControlError(http.StatusBadRequest, "incorrect request: %v", err)
+			}
+			return p.runControl(req, func(ctx context.Context) (interface{}, error) {
+				return run(ctx, r)
+			})
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(ControlStatusPath, controlHandler(http.MethodGet, func(*http.Request) (interface{}, error) {
+		return p.Status(), nil
+	}))
+	mux.Handle(ControlSecretsPath, controlHandler(http.MethodGet, inLoop(p.controlSecrets)))
+	mux.Handle(ControlFilesPath, controlHandler(http.MethodGet, inLoop(p.controlFiles)))
+	mux.Handle(ControlTokenPath, controlHandler(http.MethodGet, inLoop(p.controlToken)))
+	mux.Handle(ControlRefreshPath, controlHandler(http.MethodPost, action(p.controlRefresh)))
+	mux.Handle(ControlRenderPath, controlHandler(http.MethodPost, action(p.controlRender)))
+	mux.Handle(ControlNotifyPath, controlHandler(http.MethodPost, action(p.controlNotify)))
+	return mux
+}
+
+func controlHandler(method string, f controlFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+
+		if req.Method != method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			encoder.Encode(ControlError{Error: "method not allowed"})
+			return
+		}
+
+		value, err := f(req)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if e, ok := err.(*controlError); ok {
+				code = e.code
+			}
+			w.WriteHeader(code)
+			encoder.Encode(ControlError{Error: err.Error()})
+			return
+		}
+		encoder.Encode(value)
+	})
+}
+
+func secretInfo(s *SecretState) SecretInfo {
+	info := SecretInfo{
+		Name:          s.Name,
+		LastFetch:     s.Timestamp,
+		LeaseDuration: s.LeaseDuration,
+		AutoUpdate:    !s.DisableAutoUpdate,
+	}
+	if ttu, known := s.TimeToUpdate(); known {
+		info.TTU = &ttu
+	}
+	if expiry, known := s.Expiry(); known {
+		info.Expiry = &expiry
+	}
+	for _, f := range s.FilesUsing {
+		info.Files = append(info.Files, f.Path)
+	}
+	return info
+}
+
+func (p *pouch) fileInfo(fc FileConfig) FileInfo {
+	path := fc.Name()
+	info := FileInfo{
+		Path:    path,
+		Secrets: p.fileSecrets(path),
+		Notify:  fc.Notify,
+	}
+	sort.Strings(info.Secrets)
+	if state, found := p.State.Files[path]; found {
+		info.LastWrite = &state.LastWrite
+	}
+	return info
+}
+
+func (p *pouch) controlSecrets(context.Context) (interface{}, error) {
+	secrets := []SecretInfo{}
+	for _, s := range p.State.Secrets {
+		secrets = append(secrets, secretInfo(s))
+	}
+	sort.Slice(secrets, func(i, j int) bool { return secrets[i].Name < secrets[j].Name })
+	return secrets, nil
+}
+
+func (p *pouch) controlFiles(context.Context) (interface{}, error) {
+	files := []FileInfo{}
+	for _, fc := range p.Files {
+		files = append(files, p.fileInfo(fc))
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+func (p *pouch) controlToken(context.Context) (interface{}, error) {
+	s, _, err := p.Vault.Request(http.MethodGet, vault.SelfTokenURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't obtain token information: %v", err)
+	}
+	var info TokenInfo
+	if s == nil || s.Data == nil {
+		return info, nil
+	}
+	info.Accessor, _ = s.Data["accessor"].(string)
+	info.ExpireTime, _ = s.Data["expire_time"].(string)
+	info.Renewable, _ = s.Data["renewable"].(bool)
+	if ttl, ok := s.Data["ttl"].(json.Number); ok {
+		info.TTL, _ = ttl.Int64()
+	}
+	if policies, ok := s.Data["policies"].([]interface{}); ok {
+		for _, policy := range policies {
+			info.Policies = append(info.Policies, fmt.Sprint(policy))
+		}
+	}
+	return info, nil
+}
+
+func (p *pouch) controlRefresh(ctx context.Context, r ControlRequest) (interface{}, error) {
+	if _, found := p.Secrets[r.Secret]; !found {
+		return nil, newControlError(http.StatusNotFound, "unknown secret: %s", r.Secret)
+	}
+	logging.WithField(logging.SecretField, r.Secret).Infof("Refreshing secret on request")
+	err := p.updateSecret(ctx, r.Secret)
+	if err != nil {
+		return nil, err
+	}
+	return secretInfo(p.State.Secrets[r.Secret]), nil
+}
+
+func (p *pouch) controlRender(ctx context.Context, r ControlRequest) (interface{}, error) {
+	fc, found := p.Files[r.File]
+	if !found {
+		return nil, newControlError(http.StatusNotFound, "unknown file: %s", r.File)
+	}
+	logging.WithField(logging.FileField, r.File).Infof("Rendering file on request")
+	err := p.resolveFile(fc)
+	if err != nil {
+		return nil, err
+	}
+	return p.fileInfo(fc), nil
+}
+
+func (p *pouch) controlNotify(ctx context.Context, r ControlRequest) (interface{}, error) {
+	if _, found := p.Notifiers[r.Notifier]; !found {
+		return nil, newControlError(http.StatusNotFound, "unknown notifier: %s", r.Notifier)
+	}
+	logging.WithField(logging.NotifierField, r.Notifier).Infof("Running notifier on request")
+	n, found := p.pendingNotifiers[r.Notifier]
+	if found {
+		delete(p.pendingNotifiers, r.Notifier)
+	} else {
+		n = &Notification{Notifier: r.Notifier}
+	}
+	p.Notify(n)
+	state := p.State.Notifiers[r.Notifier]
+	if state.LastError != "" {
+		return nil, fmt.Errorf("notifier failed: %s", state.LastError)
+	}
+	return NotifierInfo{Name: r.Notifier, NotifierState: *state}, nil
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func controlCall(handler http.Handler, method, path, body string, result interface{}) int {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	json.Unmarshal(w.Body.Bytes(), result)
+	return w.Code
+}
+
+func TestControlAPI(t *testing.T) {
+	v := &DummyVault{
+		T:             t,
+		Token:         "token",
+		ExpectedToken: "token",
+		Responses: map[string]*api.Secret{
+			"GET/v1/foo": &api.Secret{
+				LeaseDuration: 3600,
+				Data:          map[string]interface{}{"foo": "secretfoo"},
+			},
+			"GET/v1/auth/token/lookup-self": &api.Secret{
+				Data: map[string]interface{}{
+					"id":        "token",
+					"accessor":  "accessor",
+					"ttl":       json.Number("600"),
+					"renewable": true,
+					"policies":  []interface{}{"default", "foo"},
+				},
+			},
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/foo", HTTPMethod: "GET"},
+	}
+	fooPath := path.Join(tmpdir, "foo")
+	files := []FileConfig{
+		{Path: fooPath, Template: `{{ secret "foo" "foo" }}`, Notify: []string{"touch"}},
+	}
+	notified := path.Join(tmpdir, "notified")
+	notifiers := map[string]NotifierConfig{
+		"touch":  {Command: "touch " + notified, Debounce: "1h"},
+		"broken": {Command: "exit 1"},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, v, secrets, files, notifiers)
+	handler := p.ControlHandler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+	defer func() {
+		cancel()
+		assert.NoError(t, <-done)
+	}()
+
+	var secretsInfo []SecretInfo
+	code := controlCall(handler, "GET", ControlSecretsPath, "", &secretsInfo)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, secretsInfo, 1) {
+		assert.Equal(t, "foo", secretsInfo[0].Name)
+		assert.Equal(t, []string{fooPath}, secretsInfo[0].Files)
+		assert.NotNil(t, secretsInfo[0].TTU)
+		assert.NotNil(t, secretsInfo[0].Expiry)
+	}
+
+	var filesInfo []FileInfo
+	code = controlCall(handler, "GET", ControlFilesPath, "", &filesInfo)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, filesInfo, 1) {
+		assert.Equal(t, fooPath, filesInfo[0].Path)
+		assert.Equal(t, []string{"foo"}, filesInfo[0].Secrets)
+		assert.NotNil(t, filesInfo[0].LastWrite)
+	}
+
+	var token TokenInfo
+	code = controlCall(handler, "GET", ControlTokenPath, "", &token)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, TokenInfo{Accessor: "accessor", TTL: 600, Renewable: true, Policies: []string{"default", "foo"}}, token)
+
+	var secretInfo SecretInfo
+	code = controlCall(handler, "POST", ControlRefreshPath, `{"secret": "foo"}`, &secretInfo)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, secretInfo.LastFetch.After(secretsInfo[0].LastFetch))
+
+	os.Remove(fooPath)
+	var fileInfo FileInfo
+	code = controlCall(handler, "POST", ControlRenderPath, `{"file": "`+fooPath+`"}`, &fileInfo)
+	assert.Equal(t, http.StatusOK, code)
+	_, err = os.Stat(fooPath)
+	assert.NoError(t, err, "File should be rendered again")
+
+	// Pending notification is run with the files that triggered it
+	var notifierInfo NotifierInfo
+	code = controlCall(handler, "POST", ControlNotifyPath, `{"notifier": "touch"}`, &notifierInfo)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "touch", notifierInfo.Name)
+	_, err = os.Stat(notified)
+	assert.NoError(t, err, "Notifier should have been run")
+
+	var controlErr ControlError
+	code = controlCall(handler, "POST", ControlNotifyPath, `{"notifier": "broken"}`, &controlErr)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, controlErr.Error, "exit status 1")
+
+	var status Status
+	code = controlCall(handler, "GET", ControlStatusPath, "", &status)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, Status{Ready: true, FailingNotifiers: []string{"broken"}}, status)
+
+	code = controlCall(handler, "POST", ControlRefreshPath, `{"secret": "unknown"}`, &controlErr)
+	assert.Equal(t, http.StatusNotFound, code)
+	code = controlCall(handler, "POST", ControlRenderPath, `{`, &controlErr)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code = controlCall(handler, "GET", ControlNotifyPath, "", &controlErr)
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// sortByDependencies sorts names so each one appears after all its
+// dependencies, names not depending on each other are sorted alphabetically.
+// It fails if there are dependency cycles.
+func sortByDependencies(names []string, dependencies map[string][]string) ([]string, error) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	sorted := make([]string, 0, len(names))
+	state := make(map[string]int)
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle found: %s", strings.Join(append(path, name), " -> "))
+		}
+		state[name] = visiting
+		deps := append([]string(nil), dependencies[name]...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			err := visit(dep, append(path, name))
+			if err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		sorted = append(sorted, name)
+		return nil
+	}
+
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	for _, name := range names {
+		err := visit(name, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// Functions available in secret templates, only intended to be used to parse
+// templates for analysis
+func secretAnalysisFuncMap() template.FuncMap {
+	funcMap := template.FuncMap{}
+	for name, f := range dataFuncMap {
+		funcMap[name] = f
+	}
+	funcMap["secret"] = analysisFuncMap["secret"]
+	return funcMap
+}
+
+// secretConfigReferences returns the references to other secrets found in
+// the templates of a secret configuration
+func secretConfigReferences(c SecretConfig) ([]secretReference, error) {
+	texts := []string{c.VaultURL, c.HTTPMethod}
+	for _, d := range c.Data {
+		if v, ok := d.(string); ok {
+			texts = append(texts, v)
+		}
+	}
+
+	var refs []secretReference
+	funcMap := secretAnalysisFuncMap()
+	for _, text := range texts {
+		t, err := template.New("secret-config").Funcs(funcMap).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		refs = append(refs, templateSecretReferences(t)...)
+	}
+	return refs, nil
+}
+
+// secretDependencies returns the secrets each secret depends on
+func (p *pouch) secretDependencies() (map[string][]string, error) {
+	dependencies := make(map[string][]string)
+	for name, c := range p.Secrets {
+		refs, err := secretConfigReferences(c)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't parse templates for secret '%s': %v", name, err)
+		}
+		for _, ref := range refs {
+			if _, found := p.Secrets[ref.Secret]; !found {
+				return nil, fmt.Errorf("secret '%s' depends on undeclared secret '%s'", name, ref.Secret)
+			}
+			dependencies[name] = append(dependencies[name], ref.Secret)
+		}
+	}
+	return dependencies, nil
+}
+
+// secretsOrder returns the names of all secrets, sorted so they can be
+// resolved after the secrets they depend on
+func (p *pouch) secretsOrder() ([]string, error) {
+	dependencies, err := p.secretDependencies()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(p.Secrets))
+	for name := range p.Secrets {
+		names = append(names, name)
+	}
+	return sortByDependencies(names, dependencies)
+}
+
+// secretDependents returns the secrets that need to be updated, in order,
+// when the given secret changes, including the secret itself
+func (p *pouch) secretDependents(name string) ([]string, error) {
+	dependencies, err := p.secretDependencies()
+	if err != nil {
+		return nil, err
+	}
+	order, err := p.secretsOrder()
+	if err != nil {
+		return nil, err
+	}
+
+	affected := map[string]bool{name: true}
+	var dependents []string
+	for _, candidate := range order {
+		for _, dep := range dependencies[candidate] {
+			if affected[dep] {
+				affected[candidate] = true
+			}
+		}
+		if affected[candidate] {
+			dependents = append(dependents, candidate)
+		}
+	}
+	return dependents, nil
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+var sortByDependenciesCases = []struct {
+	Names        []string
+	Dependencies map[string][]string
+	Sorted       []string
+	Cycle        bool
+}{
+	{
+		Names:  []string{"c", "b", "a"},
+		Sorted: []string{"a", "b", "c"},
+	},
+	{
+		Names:        []string{"a", "b", "c"},
+		Dependencies: map[string][]string{"a": {"c"}, "c": {"b"}},
+		Sorted:       []string{"b", "c", "a"},
+	},
+	{
+		Names:        []string{"a", "b", "c", "d"},
+		Dependencies: map[string][]string{"a": {"d", "b"}, "b": {"d"}},
+		Sorted:       []string{"d", "b", "a", "c"},
+	},
+	{
+		Names:        []string{"a", "b", "c"},
+		Dependencies: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}},
+		Cycle:        true,
+	},
+	{
+		Names:        []string{"a"},
+		Dependencies: map[string][]string{"a": {"a"}},
+		Cycle:        true,
+	},
+}
+
+func TestSortByDependencies(t *testing.T) {
+	for _, c := range sortByDependenciesCases {
+		sorted, err := sortByDependencies(c.Names, c.Dependencies)
+		if c.Cycle {
+			assert.Error(t, err)
+			continue
+		}
+		if assert.NoError(t, err) {
+			assert.Equal(t, c.Sorted, sorted)
+		}
+	}
+}
+
+func TestSecretDependencies(t *testing.T) {
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/db": &api.Secret{
+				Data: map[string]interface{}{"role": "readonly"},
+			},
+			"GET/v1/database/creds/readonly": &api.Secret{
+				Data: map[string]interface{}{"username": "user", "password": "pass"},
+			},
+			"POST/v1/pki/issue/readonly": &api.Secret{
+				Data: map[string]interface{}{"certificate": "cert"},
+			},
+		},
+	}
+	secrets := map[string]SecretConfig{
+		"creds": {
+			VaultURL:   `/v1/database/creds/{{ secret "db" "role" }}`,
+			HTTPMethod: "GET",
+		},
+		"db": {
+			VaultURL:   "/v1/secret/db",
+			HTTPMethod: "GET",
+		},
+		"cert": {
+			VaultURL:   `/v1/pki/issue/{{ secret "db" "role" }}`,
+			HTTPMethod: "POST",
+			Data:       SecretData{"common_name": `{{ secret "creds" "username" }}`},
+		},
+		"other": {
+			VaultURL:   "/v1/secret/other",
+			HTTPMethod: "GET",
+		},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, v, secrets, nil, nil).(*pouch)
+
+	order, err := p.secretsOrder()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"db", "creds", "cert", "other"}, order)
+	}
+
+	dependents, err := p.secretDependents("creds")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"creds", "cert"}, dependents)
+	}
+
+	delete(p.Secrets, "other")
+	for _, name := range order[:3] {
+		_, err := p.resolveSecret(name, p.Secrets[name])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, "pass", state.Secrets["creds"].Data["password"])
+	assert.Equal(t, "cert", state.Secrets["cert"].Data["certificate"])
+
+	p.Secrets["db"] = SecretConfig{VaultURL: `/v1/secret/{{ secret "cert" "certificate" }}`}
+	_, err = p.secretsOrder()
+	assert.Error(t, err, "Dependency cycle should be detected")
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/logging"
+)
+
+const (
+	// Name of the symlink pointing to the current version of the data
+	// in directories
+	DirectoryDataLink = "..data"
+
+	directoryDataTmpLink   = "..data_tmp"
+	directoryVersionPrefix = ".."
+	directoryVersionFormat = "..2006_01_02_15_04_05."
+)
+
+func (p *pouch) resolveDirectory(fc FileConfig) error {
+	mode := os.FileMode(fc.Mode)
+	if mode == 0 {
+		mode = DefaultFileMode
+	}
+
+	secret, found := p.State.Secrets[fc.Secret]
+	if !found {
+		return fmt.Errorf("unknown secret: %s", fc.Secret)
+	}
+	secret.RegisterUsage(fc.Directory, fc.Priority)
+
+	files := make(map[string][]byte)
+	for key, value := range secret.Data {
+		content, err := formatValueString(value)
+		if err != nil {
+			return fmt.Errorf("couldn't write key '%s' of secret '%s': %v", key, fc.Secret, err)
+		}
+		files[key] = []byte(content)
+	}
+
+	err := writeDirectory(fc.Directory, files, mode)
+	if err != nil {
+		p.auditFile(fc.Directory, nil, mode, err)
+		return err
+	}
+	for name, content := range files {
+		p.auditFile(filepath.Join(fc.Directory, name), content, mode, nil)
+	}
+
+	logging.WithField(logging.FileField, fc.Directory).Infof("Written %d files", len(files))
+
+	p.fileWritten(fc)
+	return nil
+}
+
+// writeDirectory atomically replaces the content of a directory with the
+// given files. Files are written to a new versioned subdirectory and a
+// symlink to it is atomically replaced, files in the directory are symlinks
+// to the files in the current version. Files not found in the new version
+// are removed. Existing files not managed by pouch are never replaced.
+func writeDirectory(dir string, files map[string][]byte, mode os.FileMode) error {
+	for name := range files {
+		if !validDirectoryFileName(name) {
+			return fmt.Errorf("invalid file name for directory '%s': %s", dir, name)
+		}
+	}
+
+	err := os.MkdirAll(dir, dirMode(mode))
+	if err != nil {
+		return err
+	}
+
+	for name := range files {
+		err = checkDirectoryFile(dir, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	versionDir, err := ioutil.TempDir(dir, time.Now().Format(directoryVersionFormat))
+	if err != nil {
+		return err
+	}
+	err = writeDirectoryVersion(versionDir, files, mode)
+	if err != nil {
+		os.RemoveAll(versionDir)
+		return err
+	}
+
+	tmpLink := filepath.Join(dir, directoryDataTmpLink)
+	os.Remove(tmpLink)
+	err = os.Symlink(filepath.Base(versionDir), tmpLink)
+	if err != nil {
+		os.RemoveAll(versionDir)
+		return err
+	}
+	err = os.Rename(tmpLink, filepath.Join(dir, DirectoryDataLink))
+	if err != nil {
+		os.Remove(tmpLink)
+		os.RemoveAll(versionDir)
+		return err
+	}
+
+	for name := range files {
+		err = linkDirectoryFile(dir, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = removeStaleDirectoryFiles(dir, files)
+	if err != nil {
+		return err
+	}
+
+	return removeStaleVersions(dir, filepath.Base(versionDir))
+}
+
+func validDirectoryFileName(name string) bool {
+	return name != "" && name != "." &&
+		!strings.HasPrefix(name, directoryVersionPrefix) &&
+		!strings.ContainsRune(name, os.PathSeparator)
+}
+
+func writeDirectoryVersion(versionDir string, files map[string][]byte, mode os.FileMode) error {
+	err := os.Chmod(versionDir, dirMode(mode))
+	if err != nil {
+		return err
+	}
+	for name, content := range files {
+		path := filepath.Join(versionDir, name)
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err != nil {
+			return fmt.Errorf("couldn't open %s file to be written: %s", path, err)
+		}
+		_, err = file.Write(content)
+		if err == nil {
+			err = file.Sync()
+		}
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("couldn't write secret in '%s': %s", path, err)
+		}
+	}
+	return nil
+}
+
+// checkDirectoryFile checks that a file in the directory doesn't exist, or
+// that it is a symlink to the current version, so it can be managed by pouch
+func checkDirectoryFile(dir, name string) error {
+	path := filepath.Join(dir, name)
+	_, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	current, err := os.Readlink(path)
+	if err != nil || current != filepath.Join(DirectoryDataLink, name) {
+		return fmt.Errorf("refusing to replace '%s', it is not managed by pouch", path)
+	}
+	return nil
+}
+
+// linkDirectoryFile ensures that a file in the directory is a symlink
+// to the file in the current version
+func linkDirectoryFile(dir, name string) error {
+	path := filepath.Join(dir, name)
+	target := filepath.Join(DirectoryDataLink, name)
+	current, err := os.Readlink(path)
+	if err == nil && current == target {
+		return nil
+	}
+	return os.Symlink(target, path)
+}
+
+// removeStaleVersions removes the versions of the data other than the
+// current one, including versions left by interrupted writes
+func removeStaleVersions(dir, current string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || name == current || !strings.HasPrefix(name, directoryVersionPrefix) {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(dir, name))
+		if err != nil {
+			return fmt.Errorf("couldn't remove old version of '%s': %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// removeStaleDirectoryFiles removes links to files not available anymore
+func removeStaleDirectoryFiles(dir string, files map[string][]byte) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if _, found := files[name]; found || strings.HasPrefix(name, directoryVersionPrefix) {
+			continue
+		}
+		if entry.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := os.Readlink(filepath.Join(dir, name))
+		if err != nil || target != filepath.Join(DirectoryDataLink, name) {
+			continue
+		}
+		err = os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
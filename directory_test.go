@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func directoryEntries(t *testing.T, dir string) (files []string, versions int) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.Name() == DirectoryDataLink:
+		case entry.IsDir():
+			versions++
+		default:
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+	return
+}
+
+func TestWriteDirectory(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+	dir := path.Join(tmpdir, "secrets")
+
+	err = writeDirectory(dir, map[string][]byte{"foo": []byte("secretfoo"), "bar": []byte("secretbar")}, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, versions := directoryEntries(t, dir)
+	assert.Equal(t, []string{"bar", "foo"}, files)
+	assert.Equal(t, 1, versions)
+
+	d, err := ioutil.ReadFile(path.Join(dir, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "secretfoo", string(d))
+
+	err = writeDirectory(dir, map[string][]byte{"foo": []byte("newfoo"), "baz": []byte("secretbaz")}, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, versions = directoryEntries(t, dir)
+	assert.Equal(t, []string{"baz", "foo"}, files, "Files for removed keys should be deleted")
+	assert.Equal(t, 1, versions, "Old versions should be deleted")
+
+	d, err = ioutil.ReadFile(path.Join(dir, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "newfoo", string(d))
+
+	err = writeDirectory(dir, map[string][]byte{"../foo": []byte("foo")}, 0600)
+	assert.Error(t, err, "Keys with path separators shouldn't be allowed")
+
+	// Versions left by interrupted writes are removed
+	err = os.Mkdir(path.Join(dir, "..2018_01_01_00_00_00.123"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = writeDirectory(dir, map[string][]byte{"foo": []byte("foo")}, 0600)
+	assert.NoError(t, err)
+	_, versions = directoryEntries(t, dir)
+	assert.Equal(t, 1, versions, "Stale versions should be deleted")
+}
+
+func TestWriteDirectoryUnmanagedFiles(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	err = ioutil.WriteFile(path.Join(tmpdir, "foo"), []byte("user file"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(path.Join(tmpdir, "bar", "baz"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"foo", "bar"} {
+		err = writeDirectory(tmpdir, map[string][]byte{name: []byte("secret")}, 0600)
+		assert.Error(t, err, "Files not managed by pouch shouldn't be replaced")
+	}
+
+	d, err := ioutil.ReadFile(path.Join(tmpdir, "foo"))
+	assert.NoError(t, err)
+	assert.Equal(t, "user file", string(d))
+	_, err = os.Stat(path.Join(tmpdir, "bar", "baz"))
+	assert.NoError(t, err)
+	_, err = os.Lstat(path.Join(tmpdir, DirectoryDataLink))
+	assert.True(t, os.IsNotExist(err), "Data shouldn't be written")
+}
+
+func TestResolveDirectory(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	state := NewState("")
+	state.Secrets = map[string]*SecretState{
+		"foo": {Name: "foo", Data: SecretData{"user": "foo", "password": "bar", "ca_chain": []interface{}{"a", "b"}}},
+	}
+	files := []FileConfig{
+		{Directory: tmpdir, Secret: "foo"},
+	}
+
+	p := NewPouch(state, nil, nil, files, nil).(*pouch)
+	err = p.resolveFile(p.Files[tmpdir])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.ReadFile(path.Join(tmpdir, "password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "bar", string(d))
+	assert.Equal(t, tmpdir, state.Secrets["foo"].FilesUsing[0].Path)
+
+	// Values that are not strings are written as JSON
+	d, err = ioutil.ReadFile(path.Join(tmpdir, "ca_chain"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `["a","b"]`, string(d))
+}
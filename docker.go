@@ -0,0 +1,229 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDockerSocket = "/var/run/docker.sock"
+	DefaultDockerAction = "kill"
+
+	// Docker Engine API version used, supported since Docker 1.12
+	dockerAPIVersion = "v1.24"
+	dockerURL        = "http://docker/" + dockerAPIVersion
+)
+
+type DockerNotifier struct {
+	Client    *http.Client
+	Container string
+	Action    string
+
+	// Signal sent with the kill action
+	Signal string
+
+	// Seconds to wait before killing the container on restart, if
+	// negative, docker default is used
+	StopTimeout int
+
+	// Command executed with the exec action
+	Command []string
+}
+
+func newDockerNotifier(config *DockerConfig) (*DockerNotifier, error) {
+	if config.Container == "" {
+		return nil, fmt.Errorf("container needed for docker notifier")
+	}
+	socket := config.Socket
+	if socket == "" {
+		socket = DefaultDockerSocket
+	}
+	n := &DockerNotifier{
+		Client:      unixSocketClient(socket),
+		Container:   config.Container,
+		Action:      config.Action,
+		StopTimeout: -1,
+		Command:     config.Command,
+	}
+	if n.Action == "" {
+		n.Action = DefaultDockerAction
+	}
+	switch n.Action {
+	case "kill":
+		signal, err := parseSignal(config.Signal)
+		if err != nil {
+			return nil, err
+		}
+		n.Signal = strconv.Itoa(int(signal))
+	case "restart":
+		if config.StopTimeout != "" {
+			timeout, err := time.ParseDuration(config.StopTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("incorrect stop timeout: %v", err)
+			}
+			n.StopTimeout = int(timeout.Seconds())
+		}
+	case "exec":
+		if len(n.Command) == 0 {
+			return nil, fmt.Errorf("command needed for docker exec")
+		}
+	default:
+		return nil, fmt.Errorf("unknown docker action: %s", n.Action)
+	}
+	return n, nil
+}
+
+func (n *DockerNotifier) Run(ctx context.Context, _ *Notification) (string, error) {
+	container := url.PathEscape(n.Container)
+	switch n.Action {
+	case "kill":
+		query := url.Values{"signal": {n.Signal}}
+		return "", n.post(ctx, "/containers/"+container+"/kill?"+query.Encode(), nil, nil)
+	case "restart":
+		path := "/containers/" + container + "/restart"
+		if n.StopTimeout >= 0 {
+			path += "?" + url.Values{"t": {strconv.Itoa(n.StopTimeout)}}.Encode()
+		}
+		return "", n.post(ctx, path, nil, nil)
+	case "exec":
+		return n.exec(ctx, container)
+	}
+	return "", fmt.Errorf("unknown docker action: %s", n.Action)
+}
+
+func (n *DockerNotifier) exec(ctx context.Context, container string) (string, error) {
+	var created struct {
+		Id string
+	}
+	execConfig := map[string]interface{}{
+		"Cmd":          n.Command,
+		"AttachStdout": true,
+		"AttachStderr": true,
+	}
+	err := n.post(ctx, "/containers/"+container+"/exec", execConfig, &created)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	startConfig := map[string]interface{}{"Detach": false, "Tty": false}
+	err = n.post(ctx, "/exec/"+created.Id+"/start", startConfig, &out)
+	if err != nil {
+		return out.String(), err
+	}
+
+	var inspect struct {
+		ExitCode int
+	}
+	err = n.request(ctx, "GET", "/exec/"+created.Id+"/json", nil, &inspect)
+	if err != nil {
+		return out.String(), err
+	}
+	if inspect.ExitCode != 0 {
+		return out.String(), fmt.Errorf("command in container %s exited with status %d", n.Container, inspect.ExitCode)
+	}
+	return out.String(), nil
+}
+
+func (n *DockerNotifier) post(ctx context.Context, path string, body, result interface{}) error {
+	return n.request(ctx, "POST", path, body, result)
+}
+
+// request sends a request to the Docker API, if result is a buffer, the
+// multiplexed output stream of the response is copied to it, otherwise the
+// response is decoded as JSON
+func (n *DockerNotifier) request(ctx context.Context, method, path string, body, result interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		d, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(d)
+	}
+	req, err := http.NewRequest(method, dockerURL+path, reqBody)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return dockerError(resp)
+	}
+
+	switch r := result.(type) {
+	case nil:
+		return nil
+	case *bytes.Buffer:
+		return demuxDockerStream(r, resp.Body)
+	default:
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+}
+
+func dockerError(resp *http.Response) error {
+	var e struct {
+		Message string `json:"message"`
+	}
+	d, _ := ioutil.ReadAll(io.LimitReader(resp.Body, webhookMaxOutput))
+	if json.Unmarshal(d, &e) == nil && e.Message != "" {
+		return fmt.Errorf("docker API error (%s): %s", resp.Status, e.Message)
+	}
+	return fmt.Errorf("docker API error (%s): %s", resp.Status, strings.TrimSpace(string(d)))
+}
+
+// demuxDockerStream copies the content of a multiplexed stream, as
+// returned when attaching to processes without TTY, to w. Each frame is
+// preceded by an 8 bytes header with the stream type and the frame size.
+func demuxDockerStream(w io.Writer, r io.Reader) error {
+	var header [8]byte
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		_, err = io.CopyN(w, r, size)
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type dummyDocker struct {
+	Requests []string
+	ExitCode int
+}
+
+func (d *dummyDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.Requests = append(d.Requests, r.Method+" "+r.URL.RequestURI())
+	switch r.URL.Path {
+	case "/" + dockerAPIVersion + "/containers/nginx/kill", "/" + dockerAPIVersion + "/containers/nginx/restart":
+		w.WriteHeader(http.StatusNoContent)
+	case "/" + dockerAPIVersion + "/containers/nginx/exec":
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"Id": "e1"}`)
+	case "/" + dockerAPIVersion + "/exec/e1/start":
+		for _, s := range []string{"reloading\n", "done\n"} {
+			header := make([]byte, 8)
+			header[0] = 1
+			binary.BigEndian.PutUint32(header[4:], uint32(len(s)))
+			w.Write(append(header, s...))
+		}
+	case "/" + dockerAPIVersion + "/exec/e1/json":
+		fmt.Fprintf(w, `{"ExitCode": %d}`, d.ExitCode)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "No such container"}`)
+	}
+}
+
+var dockerNotifierCases = []struct {
+	Config   DockerConfig
+	Requests []string
+	Output   string
+	ExitCode int
+	Error    bool
+}{
+	{
+		Config:   DockerConfig{Container: "nginx"},
+		Requests: []string{"POST /v1.24/containers/nginx/kill?signal=1"},
+	},
+	{
+		Config:   DockerConfig{Container: "nginx", Action: "kill", Signal: "USR2"},
+		Requests: []string{"POST /v1.24/containers/nginx/kill?signal=12"},
+	},
+	{
+		Config:   DockerConfig{Container: "nginx", Action: "restart", StopTimeout: "30s"},
+		Requests: []string{"POST /v1.24/containers/nginx/restart?t=30"},
+	},
+	{
+		Config: DockerConfig{Container: "nginx", Action: "exec", Command: []string{"nginx", "-s", "reload"}},
+		Requests: []string{
+			"POST /v1.24/containers/nginx/exec",
+			"POST /v1.24/exec/e1/start",
+			"GET /v1.24/exec/e1/json",
+		},
+		Output: "reloading\ndone\n",
+	},
+	{
+		Config:   DockerConfig{Container: "nginx", Action: "exec", Command: []string{"false"}},
+		ExitCode: 1,
+		Output:   "reloading\ndone\n",
+		Error:    true,
+	},
+	{
+		Config: DockerConfig{Container: "apache"},
+		Error:  true,
+	},
+}
+
+func TestDockerNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-test-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docker := &dummyDocker{}
+	server := &http.Server{Handler: docker}
+	go server.Serve(l)
+	defer server.Close()
+
+	for _, c := range dockerNotifierCases {
+		docker.Requests = nil
+		docker.ExitCode = c.ExitCode
+		c.Config.Socket = socket
+		runner, err := newDockerNotifier(&c.Config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := runner.Run(context.Background(), testNotification)
+		if c.Error {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Equal(t, c.Requests, docker.Requests)
+		}
+		assert.Equal(t, c.Output, out)
+	}
+}
+
+func TestDockerNotifierConfig(t *testing.T) {
+	_, err := newDockerNotifier(&DockerConfig{})
+	assert.Error(t, err, "Container should be required")
+
+	_, err = newDockerNotifier(&DockerConfig{Container: "foo", Action: "exec"})
+	assert.Error(t, err, "Command should be required for exec")
+
+	_, err = newDockerNotifier(&DockerConfig{Container: "foo", Action: "pause"})
+	assert.Error(t, err, "Unknown actions should fail")
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+const DynamicSecretPrefix = "vault:"
+
+// dynamicSecret builds the configuration of a secret referenced from a
+// template, data is passed as a list of key=value pairs. Secrets without
+// data are read with GET, and with POST otherwise.
+func dynamicSecret(urlPath string, data []string) (string, SecretConfig, error) {
+	c := SecretConfig{
+		VaultURL:   urlPath,
+		HTTPMethod: http.MethodGet,
+	}
+	if len(data) > 0 {
+		c.HTTPMethod = http.MethodPost
+		c.Data = make(SecretData)
+		for _, d := range data {
+			parts := strings.SplitN(d, "=", 2)
+			if len(parts) != 2 {
+				return "", c, fmt.Errorf("incorrect data for '%s', expected key=value, found: %s", urlPath, d)
+			}
+			c.Data[parts[0]] = parts[1]
+		}
+	}
+	return dynamicSecretName(c.HTTPMethod, urlPath, data), c, nil
+}
+
+// dynamicSecretName generates a name for a secret referenced from a
+// template, data is hashed so it doesn't appear in names
+func dynamicSecretName(method, urlPath string, data []string) string {
+	name := DynamicSecretPrefix + method + ":" + urlPath
+	if len(data) == 0 {
+		return name
+	}
+	sorted := append([]string(nil), data...)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return fmt.Sprintf("%s#%x", name, sum[:4])
+}
+
+// vaultFunc returns the template function used to read secrets that are not
+// declared in the Pouchfile, they are requested on first use and stored in
+// the state as any other secret. Their data is not stored in the state, it
+// is taken from the templates each time they are rendered.
+func (p *pouch) vaultFunc(fc FileConfig) func(string, ...string) (SecretData, error) {
+	return func(urlPath string, data ...string) (SecretData, error) {
+		name, c, err := dynamicSecret(urlPath, data)
+		if err != nil {
+			return nil, err
+		}
+		p.Secrets[name] = c
+		secret, found := p.State.Secrets[name]
+		if !found {
+			_, err := p.resolveSecret(name, c)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read '%s': %v", urlPath, err)
+			}
+			secret = p.State.Secrets[name]
+			stored := c
+			stored.Data = nil
+			secret.Config = &stored
+		}
+		secret.RegisterUsage(fc.Path, fc.Priority)
+		return secret.Data, nil
+	}
+}
+
+// pruneDynamicSecrets forgets secrets read from templates that are not used
+// anymore by any file
+func (p *pouch) pruneDynamicSecrets() {
+	for name, s := range p.State.Secrets {
+		if s.Config != nil && len(s.FilesUsing) == 0 {
+			p.State.DeleteSecret(name)
+			deleteSecretMetrics(name)
+			delete(p.Secrets, name)
+		}
+	}
+}
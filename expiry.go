@@ -0,0 +1,107 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"time"
+
+	"github.com/tuenti/pouch/pkg/logging"
+)
+
+// Levels of expiry warnings, as the portion of the time between the TTU and
+// the expiry of a secret that has passed without being able to update it
+var expiryWarningLevels = []float64{0.5, 0.75, 0.9, 1}
+
+// Warnings from this level on are logged as errors
+const expiryErrorLevel = 2
+
+func (p *pouch) OnExpiryWarning(notifier string) {
+	p.onExpiryWarning = notifier
+}
+
+// expiryWarningLevel returns how close a secret is to expire, zero if it is
+// not close or its expiry is not known
+func expiryWarningLevel(s *SecretState, now time.Time) int {
+	ttu, known := s.TimeToUpdate()
+	if !known {
+		return 0
+	}
+	expiry, known := s.Expiry()
+	if !known {
+		return 0
+	}
+
+	progress := 1.0
+	if window := expiry.Sub(ttu); window > 0 {
+		progress = float64(now.Sub(ttu)) / float64(window)
+	} else if now.Before(expiry) {
+		progress = 0
+	}
+
+	level := 0
+	for _, l := range expiryWarningLevels {
+		if progress >= l {
+			level++
+		}
+	}
+	return level
+}
+
+// checkExpiry is called when a secret couldn't be updated, it warns if the
+// secret is closer to its expiry than in previous checks, running the
+// expiry warning notifier if any
+func (p *pouch) checkExpiry(name string, updateErr error) {
+	s, found := p.State.Secrets[name]
+	if !found {
+		return
+	}
+	level := expiryWarningLevel(s, time.Now())
+	if level <= s.ExpiryWarnings {
+		return
+	}
+	s.ExpiryWarnings = level
+
+	expiry, _ := s.Expiry()
+	logger := logging.WithFields(logging.Fields{
+		logging.SecretField: name,
+		logging.ErrorField:  updateErr,
+		"expiry":            expiry.Format(time.RFC3339),
+	})
+	switch {
+	case level == len(expiryWarningLevels):
+		logger.Errorf("Secret expired without being updated")
+	case level >= expiryErrorLevel:
+		logger.Errorf("Secret couldn't be updated, it expires in %s", time.Until(expiry).Round(time.Second))
+	default:
+		logger.Warnf("Secret couldn't be updated, it expires in %s", time.Until(expiry).Round(time.Second))
+	}
+
+	err := p.State.Save()
+	if err != nil {
+		logging.Errorf("Couldn't save state: %s", err)
+	}
+	p.updateStatus()
+	p.NotifyStatus("%s", p.statusSummary(nil, time.Time{}))
+
+	if p.onExpiryWarning != "" {
+		n := &Notification{Notifier: p.onExpiryWarning, Secrets: []string{name}}
+		for _, f := range s.FilesUsing {
+			n.add(f.Path, nil)
+		}
+		p.Notify(n)
+	}
+}
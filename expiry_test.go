@@ -0,0 +1,170 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryWarningLevel(t *testing.T) {
+	now := time.Now()
+
+	// TTU after 750s, expiry after 1000s
+	s := &SecretState{Name: "foo", Timestamp: now, LeaseDuration: 1000}
+	cases := []struct {
+		elapsed time.Duration
+		level   int
+	}{
+		{700 * time.Second, 0},
+		{800 * time.Second, 0},
+		{900 * time.Second, 1},
+		{950 * time.Second, 2},
+		{980 * time.Second, 3},
+		{1100 * time.Second, 4},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.level, expiryWarningLevel(s, now.Add(c.elapsed)), "after %s", c.elapsed)
+	}
+
+	unknown := &SecretState{Name: "bar", Timestamp: now}
+	assert.Equal(t, 0, expiryWarningLevel(unknown, now.Add(time.Hour)))
+}
+
+func TestCheckExpiry(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	warned := path.Join(tmpdir, "warned")
+	notifiers := map[string]NotifierConfig{
+		"warn": {Command: "touch " + warned},
+	}
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, nil, nil, nil, notifiers).(*pouch)
+	p.OnExpiryWarning("warn")
+	assert.NoError(t, p.checkNotifiers())
+	p.ready = true
+
+	// Half of the time between TTU and expiry has passed
+	state.Secrets = map[string]*SecretState{
+		"foo": {Name: "foo", Timestamp: time.Now().Add(-900 * time.Second), LeaseDuration: 1000},
+	}
+	p.checkExpiry("foo", errors.New("vault is sealed"))
+	assert.Equal(t, 1, state.Secrets["foo"].ExpiryWarnings)
+	_, err = os.Stat(warned)
+	assert.NoError(t, err, "Expiry warning notifier should have been run")
+	status := p.Status()
+	assert.Equal(t, []string{"foo"}, status.ExpiringSecrets)
+	assert.False(t, status.Healthy())
+	assert.Equal(t, "secrets close to expiry: foo", status.String())
+
+	// Notifier is not run again till the warning escalates
+	os.Remove(warned)
+	p.checkExpiry("foo", errors.New("vault is sealed"))
+	_, err = os.Stat(warned)
+	assert.True(t, os.IsNotExist(err), "Expiry warning notifier shouldn't have been run")
+
+	state.Secrets["foo"].Timestamp = time.Now().Add(-2000 * time.Second)
+	p.checkExpiry("foo", errors.New("vault is sealed"))
+	assert.Equal(t, len(expiryWarningLevels), state.Secrets["foo"].ExpiryWarnings)
+	_, err = os.Stat(warned)
+	assert.NoError(t, err, "Expiry warning notifier should have been run")
+
+	// Warnings are cleared once the secret is updated
+	state.SetSecret("foo", &api.Secret{LeaseDuration: 1000})
+	p.updateStatus()
+	assert.True(t, p.Status().Healthy())
+
+	p.OnExpiryWarning("unknown")
+	assert.Error(t, p.checkNotifiers())
+}
+
+func TestExpiryWarningOnRejectedUpdate(t *testing.T) {
+	v := &DummyVault{
+		T:             t,
+		Token:         "token",
+		ExpectedToken: "token",
+		Errors: map[string]*api.Response{
+			"GET/v1/foo": {Response: &http.Response{StatusCode: http.StatusForbidden}},
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/foo", HTTPMethod: "GET"},
+	}
+	files := []FileConfig{
+		{Path: path.Join(tmpdir, "foo"), Template: `{{ secret "foo" "foo" }}`},
+	}
+	warned := path.Join(tmpdir, "warned")
+	notifiers := map[string]NotifierConfig{
+		"warn": {Command: "touch " + warned},
+	}
+
+	// Half of the time between TTU and expiry has passed, and the policy
+	// doesn't allow to read the secret anymore
+	state, cleanup := newTestState()
+	defer cleanup()
+	state.SetSecret("foo", &api.Secret{
+		LeaseDuration: 1000,
+		Data:          map[string]interface{}{"foo": "secretfoo"},
+	})
+	state.Secrets["foo"].Timestamp = time.Now().Add(-900 * time.Second)
+	p := NewPouch(state, v, secrets, files, notifiers)
+	p.OnExpiryWarning("warn")
+	handler := p.ControlHandler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	time.Sleep(100 * time.Millisecond)
+	status := p.Status()
+	assert.True(t, status.Ready)
+	assert.Equal(t, []string{"foo"}, status.ExpiringSecrets)
+	_, err = os.Stat(warned)
+	assert.NoError(t, err, "Expiry warning notifier should have been run")
+
+	// Pouch keeps running, and answering control requests
+	reqCtx, cancelReq := context.WithTimeout(context.Background(), time.Second)
+	defer cancelReq()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", ControlSecretsPath, nil).WithContext(reqCtx))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, 1, v.Requests["GET/v1/foo"], "Rejected update should be retried after the retry period")
+}
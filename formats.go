@@ -0,0 +1,287 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+const DefaultAWSProfile = "default"
+
+type formatValue struct {
+	Key   string
+	Value interface{}
+}
+
+type fileFormatter func(values []formatValue, fc FileConfig) (string, error)
+
+var fileFormatters = map[string]fileFormatter{
+	"dotenv":          formatDotenv,
+	"json":            formatJSON,
+	"yaml":            formatYAML,
+	"properties":      formatProperties,
+	"aws-credentials": formatAWSCredentials,
+}
+
+// Decoders of file contents, used to write binary files from encoded values
+var contentDecoders = map[string]func(string) ([]byte, error){
+	"base64": decodeBase64,
+	"hex":    hex.DecodeString,
+}
+
+// Keys used by default in AWS credentials files, as returned by the AWS
+// secrets backend
+var defaultAWSCredentialsKeys = map[string]string{
+	"access_key":     "aws_access_key_id",
+	"secret_key":     "aws_secret_access_key",
+	"security_token": "aws_session_token",
+}
+
+// secretReferences returns the references to the secret of files that
+// are not generated from templates
+func (fc *FileConfig) secretReferences() []secretReference {
+	if len(fc.Keys) == 0 {
+		// All keys of the secret are used
+		return []secretReference{{Secret: fc.Secret}}
+	}
+	var refs []secretReference
+	for key := range fc.Keys {
+		refs = append(refs, secretReference{Secret: fc.Secret, Key: key})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Key < refs[j].Key })
+	return refs
+}
+
+func (p *pouch) formatFileContent(fc FileConfig) (string, error) {
+	secret, found := p.State.Secrets[fc.Secret]
+	if !found {
+		return "", fmt.Errorf("unknown secret: %s", fc.Secret)
+	}
+
+	if formatter, found := keystoreFormatters[fc.Format]; found {
+		return p.keystoreFileContent(fc, secret, formatter)
+	}
+
+	formatter, found := fileFormatters[fc.Format]
+	if !found {
+		return "", fmt.Errorf("unknown format for file %s: %s", fc.Path, fc.Format)
+	}
+
+	keys := fc.Keys
+	optional := false
+	if len(keys) == 0 && fc.Format == "aws-credentials" {
+		keys = defaultAWSCredentialsKeys
+		optional = true
+	}
+	values, err := selectFormatValues(secret.Data, keys, optional)
+	if err != nil {
+		return "", fmt.Errorf("when formatting secret '%s': %v", fc.Secret, err)
+	}
+
+	secret.RegisterUsage(fc.Path, fc.Priority)
+	return formatter(values, fc)
+}
+
+// selectFormatValues selects the values of the data in keys, using the names
+// mapped, sorted by name. If no keys are given, all values are selected.
+func selectFormatValues(data SecretData, keys map[string]string, optional bool) ([]formatValue, error) {
+	var values []formatValue
+	if len(keys) == 0 {
+		for key, value := range data {
+			values = append(values, formatValue{Key: key, Value: value})
+		}
+	} else {
+		for key, name := range keys {
+			value, found := data[key]
+			if !found {
+				if optional {
+					continue
+				}
+				return nil, fmt.Errorf("unknown key: %s", key)
+			}
+			if name == "" {
+				name = key
+			}
+			values = append(values, formatValue{Key: name, Value: value})
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Key < values[j].Key })
+	return values, nil
+}
+
+// formatValueString returns the string of a value, values that are not
+// strings, like lists or maps, are encoded as JSON
+func formatValueString(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	d, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
+}
+
+func formatValuesMap(values []formatValue) map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, v := range values {
+		m[v.Key] = v.Value
+	}
+	return m
+}
+
+var dotenvKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+var dotenvReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"$", `\$`,
+	"`", "\\`",
+	"\n", `\n`,
+)
+
+func formatDotenv(values []formatValue, fc FileConfig) (string, error) {
+	var b bytes.Buffer
+	for _, v := range values {
+		if !dotenvKeyRegexp.MatchString(v.Key) {
+			return "", fmt.Errorf("invalid variable name for dotenv file: %s", v.Key)
+		}
+		value, err := formatValueString(v.Value)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"\n", v.Key, dotenvReplacer.Replace(value))
+	}
+	return b.String(), nil
+}
+
+func formatJSON(values []formatValue, fc FileConfig) (string, error) {
+	d, err := json.MarshalIndent(formatValuesMap(values), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(d) + "\n", nil
+}
+
+func formatYAML(values []formatValue, fc FileConfig) (string, error) {
+	d, err := yaml.Marshal(formatValuesMap(values))
+	if err != nil {
+		return "", err
+	}
+	return string(d), nil
+}
+
+// escapeProperty escapes a string to be used in a Java properties file,
+// characters out of ISO 8859-1 are written as unicode escapes
+func escapeProperty(s string, key bool) string {
+	var b bytes.Buffer
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == '=' || r == ':' || r == '#' || r == '!':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == ' ' && (key || i == 0):
+			b.WriteString(`\ `)
+		case r < 0x20 || r > 0x7e:
+			if r > 0xffff {
+				// Encoded as UTF-16 surrogate pairs
+				r -= 0x10000
+				fmt.Fprintf(&b, `\u%04x\u%04x`, 0xd800+(r>>10), 0xdc00+(r&0x3ff))
+			} else {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func formatProperties(values []formatValue, fc FileConfig) (string, error) {
+	var b bytes.Buffer
+	for _, v := range values {
+		value, err := formatValueString(v.Value)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s=%s\n", escapeProperty(v.Key, true), escapeProperty(value, false))
+	}
+	return b.String(), nil
+}
+
+func formatAWSCredentials(values []formatValue, fc FileConfig) (string, error) {
+	profile := fc.Profile
+	if profile == "" {
+		profile = DefaultAWSProfile
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "[%s]\n", profile)
+	for _, v := range values {
+		value, err := formatValueString(v.Value)
+		if err != nil {
+			return "", err
+		}
+		if strings.ContainsAny(v.Key, "=[]\n") || strings.ContainsAny(value, "\n") {
+			return "", fmt.Errorf("invalid value for AWS credentials file: %s", v.Key)
+		}
+		fmt.Fprintf(&b, "%s = %s\n", v.Key, value)
+	}
+	return b.String(), nil
+}
+
+// decodeContent decodes the content of a file, spaces are ignored so
+// encoded values can be wrapped or surrounded by new lines
+func decodeContent(decoding, content string) (string, error) {
+	if decoding == "" {
+		return content, nil
+	}
+	decoder, found := contentDecoders[decoding]
+	if !found {
+		return "", fmt.Errorf("unknown decoding: %s", decoding)
+	}
+	d, err := decoder(strings.Join(strings.Fields(content), ""))
+	if err != nil {
+		return "", fmt.Errorf("couldn't decode %s content: %v", decoding, err)
+	}
+	return string(d), nil
+}
+
+func decodeBase64(s string) ([]byte, error) {
+	if strings.HasSuffix(s, "=") || len(s)%4 == 0 {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
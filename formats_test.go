@@ -0,0 +1,253 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var formatSecret = SecretData{
+	"user":     "foo",
+	"password": "p$ss\"w\\rd\n",
+	"port":     json.Number("5432"),
+}
+
+var formatCases = []struct {
+	File     FileConfig
+	Data     SecretData
+	Expected string
+	Error    bool
+}{
+	{
+		File:     FileConfig{Format: "dotenv"},
+		Data:     formatSecret,
+		Expected: "password=\"p\\$ss\\\"w\\\\rd\\n\"\nport=\"5432\"\nuser=\"foo\"\n",
+	},
+	{
+		File:     FileConfig{Format: "dotenv", Keys: map[string]string{"user": "DB_USER", "port": "DB_PORT"}},
+		Data:     formatSecret,
+		Expected: "DB_PORT=\"5432\"\nDB_USER=\"foo\"\n",
+	},
+	{
+		File:  FileConfig{Format: "dotenv", Keys: map[string]string{"user": "DB-USER"}},
+		Data:  formatSecret,
+		Error: true,
+	},
+	{
+		File:  FileConfig{Format: "dotenv", Keys: map[string]string{"unknown": "UNKNOWN"}},
+		Data:  formatSecret,
+		Error: true,
+	},
+	{
+		File:     FileConfig{Format: "json", Keys: map[string]string{"user": "", "port": ""}},
+		Data:     formatSecret,
+		Expected: "{\n  \"port\": 5432,\n  \"user\": \"foo\"\n}\n",
+	},
+	{
+		File:     FileConfig{Format: "yaml", Keys: map[string]string{"user": "username", "port": ""}},
+		Data:     formatSecret,
+		Expected: "port: 5432\nusername: foo\n",
+	},
+	{
+		File:     FileConfig{Format: "properties", Keys: map[string]string{"password": "db.pass word"}},
+		Data:     SecretData{"password": " a=b:c#dñ\n"},
+		Expected: "db.pass\\ word=\\ a\\=b\\:c\\#d\\u00f1\\n\n",
+	},
+	{
+		File:     FileConfig{Format: "aws-credentials"},
+		Data:     SecretData{"access_key": "AKIA", "secret_key": "secret"},
+		Expected: "[default]\naws_access_key_id = AKIA\naws_secret_access_key = secret\n",
+	},
+	{
+		File:     FileConfig{Format: "aws-credentials", Profile: "foo"},
+		Data:     SecretData{"access_key": "AKIA", "secret_key": "secret", "security_token": "token"},
+		Expected: "[foo]\naws_access_key_id = AKIA\naws_secret_access_key = secret\naws_session_token = token\n",
+	},
+	{
+		File:     FileConfig{Format: "properties", Keys: map[string]string{"ca_chain": "", "extra": ""}},
+		Data:     SecretData{"ca_chain": []interface{}{"a", "b"}, "extra": map[string]interface{}{"k": "v"}},
+		Expected: "ca_chain=[\"a\",\"b\"]\nextra={\"k\"\\:\"v\"}\n",
+	},
+	{
+		File:  FileConfig{Format: "unknown"},
+		Data:  formatSecret,
+		Error: true,
+	},
+}
+
+func TestFormatFileContent(t *testing.T) {
+	for _, c := range formatCases {
+		state := NewState("")
+		state.Secrets = map[string]*SecretState{
+			"foo": {Name: "foo", Data: c.Data},
+		}
+		c.File.Path = "/foo"
+		c.File.Secret = "foo"
+		p := NewPouch(state, nil, nil, []FileConfig{c.File}, nil).(*pouch)
+
+		content, err := p.fileContent(c.File)
+		if c.Error {
+			assert.Error(t, err, c.File.Format)
+			continue
+		}
+		if assert.NoError(t, err, c.File.Format) {
+			assert.Equal(t, c.Expected, content)
+			assert.Equal(t, "/foo", state.Secrets["foo"].FilesUsing[0].Path)
+		}
+	}
+}
+
+func testCertificateData(t *testing.T) SecretData {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "foo.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return SecretData{
+		"certificate": certificate,
+		"issuing_ca":  certificate,
+		"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})),
+	}
+}
+
+func TestKeystoreFileContent(t *testing.T) {
+	state := NewState("")
+	state.Secrets = map[string]*SecretState{
+		"cert":     {Name: "cert", Data: testCertificateData(t)},
+		"password": {Name: "password", Data: SecretData{"password": "secret"}},
+	}
+
+	for _, format := range []string{"pkcs12", "jks", "jks-truststore"} {
+		fc := FileConfig{
+			Path:     "/foo." + format,
+			Secret:   "cert",
+			Format:   format,
+			Password: `{{ secret "password" "password" }}`,
+		}
+		p := NewPouch(state, nil, nil, []FileConfig{fc}, nil).(*pouch)
+
+		content, err := p.fileContent(fc)
+		if assert.NoError(t, err, format) {
+			assert.NotEmpty(t, content)
+		}
+
+		fc.Password = ""
+		_, err = p.fileContent(fc)
+		assert.Error(t, err, "Password should be required")
+	}
+
+	assert.Equal(t, 3, len(state.Secrets["cert"].FilesUsing))
+	assert.Equal(t, 3, len(state.Secrets["password"].FilesUsing), "Keystores should be updated when password changes")
+
+	delete(state.Secrets["cert"].Data, "private_key")
+	fc := FileConfig{Path: "/foo", Secret: "cert", Format: "jks", Password: "foo"}
+	p := NewPouch(state, nil, nil, []FileConfig{fc}, nil).(*pouch)
+	_, err := p.fileContent(fc)
+	assert.Error(t, err, "Private key should be required")
+}
+
+func TestSecretKeystoreEntryChain(t *testing.T) {
+	read := func(name string) string {
+		d, err := ioutil.ReadFile(path.Join("pkg", "keystore", "testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(d)
+	}
+	chain := read("openssl-chain.pem")
+	intermediate := chain[strings.Index(chain, "-----END CERTIFICATE-----")+len("-----END CERTIFICATE-----\n"):]
+	expected := []string{"foo.example.com", "Pouch Test Intermediate CA", "Pouch Test Root CA"}
+
+	// Intermediate only in the certificate, and in both the certificate
+	// and the CA chain
+	for _, cas := range [][]interface{}{
+		{read("openssl-ca.pem")},
+		{intermediate, read("openssl-ca.pem")},
+	} {
+		data := SecretData{
+			"private_key": read("openssl-key.pem"),
+			"certificate": chain,
+			"ca_chain":    cas,
+		}
+		entry, err := secretKeystoreEntry(data, "foo")
+		if !assert.NoError(t, err) {
+			continue
+		}
+		var names []string
+		for _, c := range entry.Chain {
+			names = append(names, c.Subject.CommonName)
+		}
+		assert.Equal(t, expected, names, "Intermediates in the certificate should be kept, without duplicates")
+	}
+}
+
+var decodeContentCases = []struct {
+	Decode   string
+	Content  string
+	Expected string
+	Error    bool
+}{
+	{"", "foo\n", "foo\n", false},
+	{"base64", "AAEC/w==\n", "\x00\x01\x02\xff", false},
+	{"base64", "AAEC/w\n", "\x00\x01\x02\xff", false},
+	{"base64", "AAEC\n/w==", "\x00\x01\x02\xff", false},
+	{"base64", "AA$EC", "", true},
+	{"hex", " 000102ff\n", "\x00\x01\x02\xff", false},
+	{"hex", "0g", "", true},
+	{"unknown", "foo", "", true},
+}
+
+func TestDecodeContent(t *testing.T) {
+	for _, c := range decodeContentCases {
+		decoded, err := decodeContent(c.Decode, c.Content)
+		if c.Error {
+			assert.Error(t, err, c.Content)
+			continue
+		}
+		if assert.NoError(t, err, c.Content) {
+			assert.Equal(t, c.Expected, decoded)
+		}
+	}
+}
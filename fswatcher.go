@@ -17,12 +17,16 @@ limitations under the License.
 package pouch
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
 
 	"github.com/fsnotify/fsnotify"
 )
@@ -44,7 +48,19 @@ func (p *pouch) handleWrapped(path string) error {
 	return nil
 }
 
-func (p *pouch) Watch(path string) error {
+// Watch waits for a wrapped secret ID to be written in path, and unwraps it
+func (p *pouch) Watch(ctx context.Context, path string) error {
+	start := time.Now()
+	err := p.watch(ctx, path)
+	if err == nil {
+		metrics.SetGauge(metricWrappedSecretIDWait, nil, time.Since(start).Seconds())
+	}
+	return err
+}
+
+func (p *pouch) watch(ctx context.Context, path string) error {
+	p.NotifyStatus("Waiting for wrapped secret ID in %s", path)
+
 	// If the file is here, we are done, try before watching
 	if _, err := os.Stat(path); !os.IsNotExist(err) {
 		err = p.handleWrapped(path)
@@ -77,8 +93,8 @@ func (p *pouch) Watch(path string) error {
 			}
 		case err := <-watcher.Errors:
 			return err
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
-
-	return nil
 }
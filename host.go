@@ -0,0 +1,113 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+)
+
+// Address used to find the interface of the default route, no traffic is
+// sent to it
+const defaultRouteProbeAddress = "192.0.2.1:9"
+
+// fqdn returns the fully qualified domain name of the host, as resolved
+// from its hostname, or the hostname if it cannot be resolved
+func fqdn() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	if cname, err := net.LookupCNAME(hostname); err == nil && cname != "" {
+		return strings.TrimSuffix(cname, "."), nil
+	}
+	addrs, err := net.LookupHost(hostname)
+	if err != nil {
+		return hostname, nil
+	}
+	for _, addr := range addrs {
+		names, err := net.LookupAddr(addr)
+		if err == nil && len(names) > 0 {
+			return strings.TrimSuffix(names[0], "."), nil
+		}
+	}
+	return hostname, nil
+}
+
+// primaryIP returns the address of the interface used by the default
+// route, or the first global address if there is no default route
+func primaryIP() (string, error) {
+	conn, err := net.Dial("udp", defaultRouteProbeAddress)
+	if err == nil {
+		defer conn.Close()
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			return addr.IP.String(), nil
+		}
+	}
+	ips, err := allIPs()
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no IP address found")
+	}
+	return ips[0], nil
+}
+
+// allIPs returns the global unicast addresses of all the interfaces
+// of the host that are up
+func allIPs() ([]string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, i := range interfaces {
+		if i.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := i.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || !ipnet.IP.IsGlobalUnicast() {
+				continue
+			}
+			ips = append(ips, ipnet.IP.String())
+		}
+	}
+	return ips, nil
+}
+
+// fileContent returns the content of a file without leading and
+// trailing spaces
+func fileContent(path string) (string, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(d)), nil
+}
+
+func join(sep string, values []string) string {
+	return strings.Join(values, sep)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/tuenti/pouch/pkg/keystore"
+)
+
+type keystoreFormatter func(data SecretData, alias, password string) ([]byte, error)
+
+var keystoreFormatters = map[string]keystoreFormatter{
+	"pkcs12":         formatPKCS12,
+	"jks":            formatJKS,
+	"jks-truststore": formatJKSTruststore,
+}
+
+func (p *pouch) keystoreFileContent(fc FileConfig, secret *SecretState, formatter keystoreFormatter) (string, error) {
+	if fc.Password == "" {
+		return "", fmt.Errorf("password needed for keystore %s", fc.Path)
+	}
+	// Password is resolved as a template so it can be read from secrets,
+	// and the keystore is updated when they change
+	password, err := getFileContent(FileConfig{Path: fc.Path, Template: fc.Password}, nil, p.fileFuncMap(fc))
+	if err != nil {
+		return "", fmt.Errorf("couldn't resolve password for keystore %s: %v", fc.Path, err)
+	}
+
+	alias := fc.Alias
+	if alias == "" {
+		alias = fc.Secret
+	}
+
+	d, err := formatter(secret.Data, alias, password)
+	if err != nil {
+		return "", fmt.Errorf("when formatting secret '%s': %v", fc.Secret, err)
+	}
+	secret.RegisterUsage(fc.Path, fc.Priority)
+	return string(d), nil
+}
+
+func secretString(data SecretData, key string) (string, bool) {
+	value, ok := data[key].(string)
+	return value, ok && value != ""
+}
+
+// secretCAs returns the CA certificates in the secret, from the chain
+// if available, or the issuing CA otherwise
+func secretCAs(data SecretData) ([]*x509.Certificate, error) {
+	var pems []string
+	switch chain := data["ca_chain"].(type) {
+	case []interface{}:
+		for _, c := range chain {
+			if s, ok := c.(string); ok {
+				pems = append(pems, s)
+			}
+		}
+	case string:
+		pems = append(pems, chain)
+	}
+	if len(pems) == 0 {
+		if ca, ok := secretString(data, "issuing_ca"); ok {
+			pems = append(pems, ca)
+		}
+	}
+
+	var cas []*x509.Certificate
+	for _, pem := range pems {
+		certificates, err := keystore.ParseCertificates(pem)
+		if err != nil {
+			return nil, err
+		}
+		cas = appendCertificates(cas, certificates...)
+	}
+	return cas, nil
+}
+
+func secretKeystoreEntry(data SecretData, alias string) (keystore.Entry, error) {
+	entry := keystore.Entry{Alias: alias}
+
+	keyPEM, ok := secretString(data, "private_key")
+	if !ok {
+		return entry, fmt.Errorf("no private key found")
+	}
+	key, err := keystore.ParsePrivateKey(keyPEM)
+	if err != nil {
+		return entry, err
+	}
+	entry.PrivateKey = key
+
+	certPEM, ok := secretString(data, "certificate")
+	if !ok {
+		return entry, fmt.Errorf("no certificate found")
+	}
+	certificates, err := keystore.ParseCertificates(certPEM)
+	if err != nil {
+		return entry, err
+	}
+	cas, err := secretCAs(data)
+	if err != nil {
+		return entry, err
+	}
+	entry.Chain = appendCertificates(certificates, cas...)
+	return entry, nil
+}
+
+// appendCertificates appends certificates not already in the list
+func appendCertificates(list []*x509.Certificate, certificates ...*x509.Certificate) []*x509.Certificate {
+	for _, c := range certificates {
+		duplicated := false
+		for _, known := range list {
+			duplicated = duplicated || bytes.Equal(known.Raw, c.Raw)
+		}
+		if !duplicated {
+			list = append(list, c)
+		}
+	}
+	return list
+}
+
+func formatPKCS12(data SecretData, alias, password string) ([]byte, error) {
+	entry, err := secretKeystoreEntry(data, alias)
+	if err != nil {
+		return nil, err
+	}
+	return keystore.EncodePKCS12(entry, password)
+}
+
+func formatJKS(data SecretData, alias, password string) ([]byte, error) {
+	entry, err := secretKeystoreEntry(data, alias)
+	if err != nil {
+		return nil, err
+	}
+	return keystore.EncodeJKS([]keystore.Entry{entry}, nil, password)
+}
+
+func formatJKSTruststore(data SecretData, alias, password string) ([]byte, error) {
+	cas, err := secretCAs(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("no CA certificate found")
+	}
+	var trusted []keystore.TrustedCertificate
+	for i, ca := range cas {
+		caAlias := alias
+		if i > 0 {
+			caAlias = fmt.Sprintf("%s-%d", alias, i)
+		}
+		trusted = append(trusted, keystore.TrustedCertificate{Alias: caAlias, Certificate: ca})
+	}
+	return keystore.EncodeJKS(nil, trusted, password)
+}
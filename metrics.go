@@ -0,0 +1,90 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+const (
+	metricReady               = "pouch_ready"
+	metricSecretLastFetch     = "pouch_secret_last_fetch_timestamp_seconds"
+	metricSecretTTU           = "pouch_secret_ttu_timestamp_seconds"
+	metricSecretExpiry        = "pouch_secret_expiry_timestamp_seconds"
+	metricSecretFetchErrors   = "pouch_secret_fetch_errors_total"
+	metricFileLastWrite       = "pouch_file_last_write_timestamp_seconds"
+	metricNotifierRuns        = "pouch_notifier_runs_total"
+	metricNotifierFailures    = "pouch_notifier_failures_total"
+	metricNotifierDuration    = "pouch_notifier_duration_seconds"
+	metricWrappedSecretIDWait = "pouch_wrapped_secret_id_wait_seconds"
+)
+
+// updateSecretMetrics exposes when a secret was read, and when it has to be
+// updated and expires, if known
+func updateSecretMetrics(s *SecretState) {
+	labels := metrics.Labels{"secret": s.Name}
+	metrics.SetTimestamp(metricSecretLastFetch, labels, s.Timestamp)
+	if ttu, known := s.TimeToUpdate(); known {
+		metrics.SetTimestamp(metricSecretTTU, labels, ttu)
+	} else {
+		metrics.Delete(metricSecretTTU, labels)
+	}
+	if expiry, known := s.Expiry(); known {
+		metrics.SetTimestamp(metricSecretExpiry, labels, expiry)
+	} else {
+		metrics.Delete(metricSecretExpiry, labels)
+	}
+}
+
+func deleteSecretMetrics(name string) {
+	labels := metrics.Labels{"secret": name}
+	metrics.Delete(metricSecretLastFetch, labels)
+	metrics.Delete(metricSecretTTU, labels)
+	metrics.Delete(metricSecretExpiry, labels)
+}
+
+// notifierMetrics records a run of a notifier, including its retries
+func notifierMetrics(name string, start time.Time, err error) {
+	labels := metrics.Labels{"notifier": name}
+	metrics.ObserveSince(metricNotifierDuration, labels, start)
+	metrics.IncrCounter(metricNotifierRuns, labels, 1)
+	failures := 0.0
+	if err != nil {
+		failures = 1
+	}
+	metrics.IncrCounter(metricNotifierFailures, labels, failures)
+}
+
+// MetricsHandler returns a handler exposing metrics in /metrics, and the
+// status of pouch in /health, that fails if it is not healthy
+func MetricsHandler(p Pouch) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, req *http.Request) {
+		status := p.Status()
+		w.Header().Set("Content-Type", "application/json")
+		if !status.Healthy() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(status)
+	})
+	return mux
+}
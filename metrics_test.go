@@ -0,0 +1,78 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getMetrics(handler http.Handler, path string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w
+}
+
+func TestMetricsHandler(t *testing.T) {
+	notifiers := map[string]NotifierConfig{
+		"metrics-ok":     {Command: "true"},
+		"metrics-broken": {Command: "exit 1"},
+	}
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, nil, nil, nil, notifiers).(*pouch)
+	handler := MetricsHandler(p)
+
+	w := getMetrics(handler, "/health")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	p.NotifyReady()
+	w = getMetrics(handler, "/health")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	p.Notify(&Notification{Notifier: "metrics-ok"})
+	p.Notify(&Notification{Notifier: "metrics-broken"})
+	w = getMetrics(handler, "/health")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	var status Status
+	if assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &status)) {
+		assert.Equal(t, []string{"metrics-broken"}, status.FailingNotifiers)
+	}
+
+	secret := &SecretState{Name: "metrics-secret", Timestamp: time.Unix(1536000000, 0), LeaseDuration: 3600}
+	updateSecretMetrics(secret)
+
+	w = getMetrics(handler, "/metrics")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `pouch_notifier_runs_total{notifier="metrics-ok"} 1`)
+	assert.Contains(t, body, `pouch_notifier_failures_total{notifier="metrics-ok"} 0`)
+	assert.Contains(t, body, `pouch_notifier_failures_total{notifier="metrics-broken"} 1`)
+	assert.Contains(t, body, `pouch_notifier_duration_seconds_count{notifier="metrics-broken"} 1`)
+	assert.Contains(t, body, `pouch_secret_last_fetch_timestamp_seconds{secret="metrics-secret"} 1.536e+09`)
+	assert.Contains(t, body, `pouch_secret_ttu_timestamp_seconds{secret="metrics-secret"} 1.5360027e+09`)
+	assert.Contains(t, body, `pouch_secret_expiry_timestamp_seconds{secret="metrics-secret"} 1.5360036e+09`)
+
+	deleteSecretMetrics("metrics-secret")
+	w = getMetrics(handler, "/metrics")
+	assert.NotContains(t, w.Body.String(), "metrics-secret")
+}
@@ -19,39 +19,232 @@ package pouch
 import (
 	"context"
 	"fmt"
-	"log"
-	"os/exec"
+	"regexp"
+	"sort"
+	"syscall"
 	"time"
+
+	"github.com/tuenti/pouch/pkg/audit"
+	"github.com/tuenti/pouch/pkg/logging"
 )
 
 const (
-	DefaultNotifyTimeout = 5 * time.Minute
+	DefaultNotifyTimeout       = 5 * time.Minute
+	DefaultNotifyRetryInterval = 10 * time.Second
 )
 
 type NotifierRunner interface {
-	Run(context.Context) (string, error)
+	Run(context.Context, *Notification) (string, error)
+}
+
+// Notification contains information about the changes that
+// triggered a notifier
+type Notification struct {
+	Notifier string   `json:"notifier"`
+	Files    []string `json:"files"`
+	Secrets  []string `json:"secrets"`
+
+	// Last time a change was added to this notification
+	lastTrigger time.Time
+}
+
+func (n *Notification) add(file string, secrets []string) {
+	n.Files = addSorted(n.Files, file)
+	for _, secret := range secrets {
+		n.Secrets = addSorted(n.Secrets, secret)
+	}
+}
+
+// addSorted adds a value to a sorted list if it is not already there
+func addSorted(list []string, value string) []string {
+	i := sort.SearchStrings(list, value)
+	if i < len(list) && list[i] == value {
+		return list
+	}
+	list = append(list, "")
+	copy(list[i+1:], list[i:])
+	list[i] = value
+	return list
 }
 
 type ServiceNotifier struct {
 	Reloader
 
 	Service string
+
+	// Action to run on the service, if empty, the service is reloaded
+	// by the reloader
+	Action string
+	Signal syscall.Signal
+
+	// Wait for the service to be active after the action
+	WaitActive bool
 }
 
-func (n *ServiceNotifier) Run(ctx context.Context) (string, error) {
-	err := n.Reload(ctx, n.Service)
-	return "", err
+func (n *ServiceNotifier) Run(ctx context.Context, _ *Notification) (string, error) {
+	controller, _ := n.Reloader.(ServiceController)
+	if n.Action == "" {
+		err := n.Reload(ctx, n.Service)
+		if err != nil {
+			return "", err
+		}
+	} else {
+		err := controller.ServiceAction(ctx, n.Service, n.Action, n.Signal)
+		if err != nil {
+			return "", err
+		}
+	}
+	if n.WaitActive {
+		return "", controller.WaitActive(ctx, n.Service)
+	}
+	return "", nil
 }
 
-type CommandNotifier struct {
-	Command string
+// notifierDuration parses an optional duration option of a notifier
+func notifierDuration(name, option, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("incorrect %s for notifier '%s': %v", option, name, err)
+	}
+	return d, nil
 }
 
-func (n *CommandNotifier) Run(ctx context.Context) (string, error) {
-	cmd := exec.CommandContext(ctx, "sh", "-c", n.Command)
-	cmd.Stdin = nil
-	out, err := cmd.CombinedOutput()
-	return string(out), err
+// checkNotifiers checks the scheduling options of notifiers, and that
+// their dependencies don't have cycles
+func (p *pouch) checkNotifiers() error {
+	for name, n := range p.Notifiers {
+		if _, err := notifierDuration(name, "debounce", n.Debounce); err != nil {
+			return err
+		}
+		if _, err := notifierDuration(name, "min_interval", n.MinInterval); err != nil {
+			return err
+		}
+		for _, dep := range n.After {
+			if _, found := p.Notifiers[dep]; !found {
+				return fmt.Errorf("notifier '%s' runs after unknown notifier '%s'", name, dep)
+			}
+		}
+		if n.OnFailure != "" {
+			if _, found := p.Notifiers[n.OnFailure]; !found {
+				return fmt.Errorf("notifier '%s' escalates to unknown notifier '%s'", name, n.OnFailure)
+			}
+		}
+	}
+	if p.onExpiryWarning != "" {
+		if _, found := p.Notifiers[p.onExpiryWarning]; !found {
+			return fmt.Errorf("unknown notifier for expiry warnings '%s'", p.onExpiryWarning)
+		}
+	}
+	_, err := p.notifiersOrder()
+	return err
+}
+
+// notifiersOrder returns the names of the notifiers sorted so each one
+// appears after the ones it has to run after
+func (p *pouch) notifiersOrder() ([]string, error) {
+	var names []string
+	dependencies := make(map[string][]string)
+	for name, n := range p.Notifiers {
+		names = append(names, name)
+		dependencies[name] = n.After
+	}
+	return sortByDependencies(names, dependencies)
+}
+
+// pendingOrder returns the order in which notifiers have to be run,
+// including unknown pending notifiers
+func (p *pouch) pendingOrder() []string {
+	order, err := p.notifiersOrder()
+	if err != nil {
+		// Checked on start, this shouldn't happen
+		logging.Errorf("Couldn't sort notifiers: %v", err)
+	}
+	var unknown []string
+	for name := range p.pendingNotifiers {
+		if _, found := p.Notifiers[name]; !found {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return append(order, unknown...)
+}
+
+// notifyPending runs pending notifiers that are due, in order. A notifier is
+// due when no change has been added to it during its debounce window, its
+// minimum interval since its last run has passed, and no notifier it has to
+// run after is waiting. It returns the next time a waiting notifier is due,
+// or zero time if none is waiting.
+func (p *pouch) notifyPending(now time.Time) (next time.Time) {
+	if len(p.pendingNotifiers) == 0 {
+		return
+	}
+	order := p.pendingOrder()
+	waiting := make(map[string]bool)
+	for _, name := range order {
+		pending, found := p.pendingNotifiers[name]
+		config := p.Notifiers[name]
+		blocked := false
+		for _, dep := range config.After {
+			blocked = blocked || waiting[dep]
+		}
+		if !found {
+			if blocked {
+				waiting[name] = true
+			}
+			continue
+		}
+
+		due := p.notifierDueTime(name, config, pending)
+		if blocked || due.After(now) {
+			waiting[name] = true
+			if !blocked && (next.IsZero() || due.Before(next)) {
+				next = due
+			}
+			continue
+		}
+
+		p.Notify(pending)
+		delete(p.pendingNotifiers, name)
+	}
+	return
+}
+
+// notifyAllPending runs all pending notifiers in order, without waiting
+// for their debounce or minimum interval
+func (p *pouch) notifyAllPending() {
+	for _, name := range p.pendingOrder() {
+		if pending, found := p.pendingNotifiers[name]; found {
+			p.Notify(pending)
+			delete(p.pendingNotifiers, name)
+		}
+	}
+}
+
+func (p *pouch) notifierDueTime(name string, config NotifierConfig, pending *Notification) time.Time {
+	debounce, _ := notifierDuration(name, "debounce", config.Debounce)
+	due := pending.lastTrigger.Add(debounce)
+
+	minInterval, _ := notifierDuration(name, "min_interval", config.MinInterval)
+	if p.State != nil && minInterval > 0 {
+		if state, found := p.State.Notifiers[name]; found {
+			if t := state.LastRun.Add(minInterval); t.After(due) {
+				due = t
+			}
+		}
+	}
+	return due
+}
+
+func countSet(values ...string) (count int) {
+	for _, v := range values {
+		if v != "" {
+			count++
+		}
+	}
+	return
 }
 
 func (p *pouch) notifierRunner(config NotifierConfig) (NotifierRunner, error) {
@@ -62,12 +255,71 @@ func (p *pouch) notifierRunner(config NotifierConfig) (NotifierRunner, error) {
 		if p.Reloader == nil {
 			return nil, fmt.Errorf("service set for notifier, but not service reloader available")
 		}
-		runner = &ServiceNotifier{Reloader: p.Reloader, Service: config.Service}
+		n := &ServiceNotifier{
+			Reloader:   p.Reloader,
+			Service:    config.Service,
+			Action:     config.Action,
+			WaitActive: config.WaitActive,
+		}
+		if n.Action != "" || n.WaitActive {
+			if _, ok := p.Reloader.(ServiceController); !ok {
+				return nil, fmt.Errorf("service manager doesn't support actions on services")
+			}
+		}
+		if n.Action == ServiceActionKill {
+			signal, err := parseSignal(config.Signal)
+			if err != nil {
+				return nil, err
+			}
+			n.Signal = signal
+		}
+		runner = n
+		count++
+	}
+
+	if config.Command != "" || len(config.Args) > 0 {
+		n, err := newCommandNotifier(config)
+		if err != nil {
+			return nil, err
+		}
+		runner = n
 		count++
 	}
 
-	if config.Command != "" {
-		runner = &CommandNotifier{Command: config.Command}
+	if config.Pidfile != "" || config.Process != "" || config.Cmdline != "" {
+		signal, err := parseSignal(config.Signal)
+		if err != nil {
+			return nil, err
+		}
+		n := &SignalNotifier{Signal: signal, Pidfile: config.Pidfile, Process: config.Process}
+		if config.Cmdline != "" {
+			n.Cmdline, err = regexp.Compile(config.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("incorrect cmdline expression: %v", err)
+			}
+		}
+		if countSet(config.Pidfile, config.Process, config.Cmdline) != 1 {
+			return nil, fmt.Errorf("only one of pidfile, process or cmdline can be set")
+		}
+		runner = n
+		count++
+	}
+
+	if config.Webhook != nil {
+		n, err := newWebhookNotifier(config.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		runner = n
+		count++
+	}
+
+	if config.Docker != nil {
+		n, err := newDockerNotifier(config.Docker)
+		if err != nil {
+			return nil, err
+		}
+		runner = n
 		count++
 	}
 
@@ -78,17 +330,85 @@ func (p *pouch) notifierRunner(config NotifierConfig) (NotifierRunner, error) {
 	return runner, nil
 }
 
-func (p *pouch) Notify(name string) {
+// Notify runs a notifier, retrying it if configured, and escalating to
+// its on failure notifier if it keeps failing
+func (p *pouch) Notify(n *Notification) {
+	p.notify(n, make(map[string]bool))
+}
+
+func (p *pouch) notify(n *Notification, visited map[string]bool) {
+	name := n.Notifier
+	logger := logging.WithField(logging.NotifierField, name)
+	visited[name] = true
 	notifier, found := p.Notifiers[name]
 	if !found {
-		log.Printf("Couldn't find notifier for '%s'", name)
+		logger.Errorf("Couldn't find notifier")
+		return
+	}
+
+	start := time.Now()
+	err := p.runNotifier(n, notifier)
+	notifierMetrics(name, start, err)
+	event := audit.Event{
+		Type:     audit.NotifierEvent,
+		Notifier: name,
+		Files:    n.Files,
+		Duration: time.Since(start).Seconds(),
+	}
+	event.SetError(err)
+	p.audit(event)
+	if p.State != nil {
+		p.State.SetNotifierResult(name, err)
+	}
+	p.updateStatus()
+	if err == nil {
+		return
+	}
+
+	escalation := notifier.OnFailure
+	if escalation == "" {
 		return
 	}
+	if visited[escalation] {
+		logger.Warnf("Not escalating to '%s', it was already run", escalation)
+		return
+	}
+	logger.Warnf("Escalating failed notification to '%s'", escalation)
+	p.notify(&Notification{Notifier: escalation, Files: n.Files, Secrets: n.Secrets}, visited)
+}
+
+// notifierMaxDuration returns the maximum time a notifier can take to run,
+// including its retries
+func notifierMaxDuration(notifier NotifierConfig) time.Duration {
+	timeout := DefaultNotifyTimeout
+	if t, err := time.ParseDuration(notifier.Timeout); err == nil {
+		timeout = t
+	}
+	retryInterval := DefaultNotifyRetryInterval
+	if i, err := time.ParseDuration(notifier.RetryInterval); err == nil {
+		retryInterval = i
+	}
+	retries := time.Duration(notifier.Retries)
+	return timeout*(retries+1) + retryInterval*retries
+}
 
+// checkNotifiersDuration warns about notifiers that can run for longer than
+// the watchdog interval, as notifiers run in the main loop and the service
+// manager could restart pouch while running them
+func (p *pouch) checkNotifiersDuration(watchdogInterval time.Duration) {
+	for name, notifier := range p.Notifiers {
+		if d := notifierMaxDuration(notifier); d >= watchdogInterval {
+			logging.WithField(logging.NotifierField, name).Warnf("Notifier can run for %s, more than the watchdog interval (%s), set a lower timeout", d, watchdogInterval)
+		}
+	}
+}
+
+func (p *pouch) runNotifier(n *Notification, notifier NotifierConfig) error {
+	logger := logging.WithField(logging.NotifierField, n.Notifier)
 	runner, err := p.notifierRunner(notifier)
 	if err != nil {
-		log.Printf("Couldn't configure notifier for '%s': %v", name, err)
-		return
+		logger.Errorf("Couldn't configure notifier: %v", err)
+		return err
 	}
 
 	timeout := DefaultNotifyTimeout
@@ -97,16 +417,40 @@ func (p *pouch) Notify(name string) {
 		if err == nil {
 			timeout = t
 		} else {
-			log.Printf("Incorrect timeout: %s", err)
+			logger.Warnf("Incorrect timeout: %s", err)
 		}
 	}
-	ctx, _ := context.WithTimeout(context.Background(), timeout)
 
-	out, err := runner.Run(ctx)
-	if err != nil {
-		log.Printf("Notification to '%s' failed: %s", name, err)
+	retryInterval := DefaultNotifyRetryInterval
+	if notifier.RetryInterval != "" {
+		i, err := time.ParseDuration(notifier.RetryInterval)
+		if err == nil {
+			retryInterval = i
+		} else {
+			logger.Warnf("Incorrect retry interval: %s", err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(p.notifyCtx, timeout)
+		out, err := runner.Run(ctx, n)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		failure := logger.WithField(logging.ErrorField, err)
 		if len(out) > 0 {
-			log.Println(string(out))
+			failure = failure.WithField("output", out)
+		}
+		failure.Warnf("Notification failed")
+		if attempt >= notifier.Retries {
+			return err
+		}
+		logger.Infof("Retrying notification in %s", retryInterval)
+		select {
+		case <-time.After(retryInterval):
+		case <-p.notifyCtx.Done():
+			return err
 		}
 	}
 }
@@ -0,0 +1,232 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/logging"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifyRetriesAndEscalation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-test-notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	counter := filepath.Join(dir, "counter")
+	escalated := filepath.Join(dir, "escalated")
+	notifiers := map[string]NotifierConfig{
+		// Fails the first time it is run
+		"flaky": {
+			Command:       fmt.Sprintf("echo >> %s; test $(wc -l < %s) -ge 2", counter, counter),
+			Retries:       1,
+			RetryInterval: "1ms",
+		},
+		"broken": {
+			Command:   "exit 1",
+			OnFailure: "restart",
+		},
+		"restart": {
+			Command: fmt.Sprintf("touch %s", escalated),
+		},
+		"loop": {
+			Command:   "exit 1",
+			OnFailure: "loop",
+		},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, nil, nil, nil, notifiers).(*pouch)
+	p.NotifyReady()
+
+	p.Notify(&Notification{Notifier: "flaky"})
+	if assert.NotNil(t, state.Notifiers["flaky"]) {
+		assert.Equal(t, 0, state.Notifiers["flaky"].Failures)
+		assert.False(t, state.Notifiers["flaky"].LastSuccess.IsZero())
+	}
+	assert.True(t, p.Status().Healthy())
+
+	p.Notify(&Notification{Notifier: "broken"})
+	if assert.NotNil(t, state.Notifiers["broken"]) {
+		assert.Equal(t, 1, state.Notifiers["broken"].Failures)
+		assert.NotEmpty(t, state.Notifiers["broken"].LastError)
+	}
+	_, err = os.Stat(escalated)
+	assert.NoError(t, err, "Escalation notifier should have been run")
+	assert.Equal(t, 0, state.Notifiers["restart"].Failures)
+
+	status := p.Status()
+	assert.False(t, status.Healthy())
+	assert.Equal(t, []string{"broken"}, status.FailingNotifiers)
+
+	p.Notify(&Notification{Notifier: "loop"})
+	assert.Equal(t, 1, state.Notifiers["loop"].Failures, "Escalation loops should be avoided")
+}
+
+func TestNotifyPendingOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-test-notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	log := filepath.Join(dir, "log")
+	command := func(name string) string {
+		return fmt.Sprintf("echo %s >> %s", name, log)
+	}
+	notifiers := map[string]NotifierConfig{
+		"etcd":           {Command: command("etcd"), Debounce: "10s"},
+		"kube-apiserver": {Command: command("kube-apiserver"), After: []string{"etcd"}},
+		"kubelet":        {Command: command("kubelet"), After: []string{"kube-apiserver"}},
+		"nginx":          {Command: command("nginx"), MinInterval: "1h"},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, nil, nil, nil, notifiers).(*pouch)
+	assert.NoError(t, p.checkNotifiers())
+
+	notifyAll := func() {
+		for name := range notifiers {
+			p.addForNotify(FileConfig{Path: "/tmp/" + name, Notify: []string{name}})
+		}
+	}
+	notifyAll()
+
+	// etcd is waiting for more changes, and the others have to run after it
+	now := time.Now()
+	next := p.notifyPending(now)
+	assert.True(t, next.After(now.Add(9*time.Second)), "Next run should be when etcd debounce finishes")
+	d, _ := ioutil.ReadFile(log)
+	assert.Equal(t, "nginx\n", string(d))
+
+	next = p.notifyPending(now.Add(time.Minute))
+	assert.True(t, next.IsZero())
+	d, _ = ioutil.ReadFile(log)
+	assert.Equal(t, "nginx\netcd\nkube-apiserver\nkubelet\n", string(d))
+
+	// nginx has to wait for its minimum interval
+	p.addForNotify(FileConfig{Path: "/tmp/nginx", Notify: []string{"nginx"}})
+	next = p.notifyPending(now.Add(time.Minute))
+	assert.True(t, next.After(now.Add(59*time.Minute)))
+	assert.Len(t, p.pendingNotifiers, 1)
+}
+
+func TestCheckNotifiers(t *testing.T) {
+	p := NewPouch(nil, nil, nil, nil, map[string]NotifierConfig{
+		"a": {Command: "true", After: []string{"b"}},
+		"b": {Command: "true", After: []string{"a"}},
+	}).(*pouch)
+	assert.Error(t, p.checkNotifiers(), "Cycles should be detected")
+
+	p = NewPouch(nil, nil, nil, nil, map[string]NotifierConfig{
+		"a": {Command: "true", After: []string{"c"}},
+	}).(*pouch)
+	assert.Error(t, p.checkNotifiers(), "Unknown notifiers should be detected")
+
+	p = NewPouch(nil, nil, nil, nil, map[string]NotifierConfig{
+		"a": {Command: "true", Debounce: "foo"},
+	}).(*pouch)
+	assert.Error(t, p.checkNotifiers(), "Incorrect durations should be detected")
+}
+
+type dummyServiceController struct {
+	Actions []string
+}
+
+func (c *dummyServiceController) Reload(ctx context.Context, service string) error {
+	c.Actions = append(c.Actions, "reload-or-restart "+service)
+	return nil
+}
+
+func (c *dummyServiceController) ServiceAction(ctx context.Context, service, action string, signal syscall.Signal) error {
+	c.Actions = append(c.Actions, fmt.Sprintf("%s %s %d", action, service, signal))
+	return nil
+}
+
+func (c *dummyServiceController) WaitActive(ctx context.Context, service string) error {
+	c.Actions = append(c.Actions, "wait "+service)
+	return nil
+}
+
+var serviceNotifierCases = []struct {
+	Config  NotifierConfig
+	Actions []string
+}{
+	{
+		Config:  NotifierConfig{Service: "nginx"},
+		Actions: []string{"reload-or-restart nginx"},
+	},
+	{
+		Config:  NotifierConfig{Service: "nginx", Action: "try-restart", WaitActive: true},
+		Actions: []string{"try-restart nginx 0", "wait nginx"},
+	},
+	{
+		Config:  NotifierConfig{Service: "nginx", Action: "kill", Signal: "USR1"},
+		Actions: []string{"kill nginx 10"},
+	},
+}
+
+func TestServiceNotifier(t *testing.T) {
+	for _, c := range serviceNotifierCases {
+		controller := &dummyServiceController{}
+		p := NewPouch(nil, nil, nil, nil, nil).(*pouch)
+		p.ServiceReloader(controller)
+		runner, err := p.notifierRunner(c.Config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = runner.Run(context.Background(), testNotification)
+		assert.NoError(t, err)
+		assert.Equal(t, c.Actions, controller.Actions)
+	}
+}
+
+func TestNotifierOutputRedacted(t *testing.T) {
+	var logs bytes.Buffer
+	logging.Default.SetOutput(&logs)
+	defer logging.Default.SetOutput(os.Stderr)
+
+	notifiers := map[string]NotifierConfig{
+		"leaky": {Command: "echo password is s3cr3tvalue; exit 1"},
+	}
+	state, cleanup := newTestState()
+	defer cleanup()
+	state.Secrets = map[string]*SecretState{
+		"db": {Name: "db", Data: SecretData{"password": "s3cr3tvalue"}},
+	}
+	p := NewPouch(state, nil, nil, nil, notifiers).(*pouch)
+	p.redactSecrets()
+	defer logging.SetSecrets(nil)
+
+	p.Notify(&Notification{Notifier: "leaky"})
+	assert.Contains(t, logs.String(), "password is "+logging.Redacted)
+	assert.NotContains(t, logs.String(), "s3cr3tvalue")
+}
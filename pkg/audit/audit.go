@@ -0,0 +1,304 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit implements an audit trail of the actions of pouch, events
+// are written as JSON lines to an append-only file or to syslog. Events
+// never contain secret values.
+package audit
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/syslog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/logging"
+)
+
+// Types of events
+const (
+	VaultRequestEvent = "vault_request"
+	LoginEvent        = "login"
+	UnwrapEvent       = "unwrap"
+	SecretEvent       = "secret"
+	FileEvent         = "file"
+	NotifierEvent     = "notifier"
+)
+
+// Results of events
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+const (
+	// Size in MB of audit files before being rotated
+	DefaultMaxSize = 100
+
+	// Rotated audit files kept
+	DefaultMaxBackups = 5
+
+	DefaultSyslogTag = "pouch"
+
+	// Size in bytes of keys used for digests
+	DigestKeySize = 32
+)
+
+// Event is an audited action, only the fields relevant for each type of
+// event are set
+type Event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Result string    `json:"result,omitempty"`
+	Error  string    `json:"error,omitempty"`
+
+	// Vault requests
+	Method string `json:"method,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Status int    `json:"status,omitempty"`
+
+	// Secrets
+	Secret        string `json:"secret,omitempty"`
+	LeaseDuration int    `json:"lease_duration,omitempty"`
+
+	// Files written, path is also used for them
+	Size   int    `json:"size,omitempty"`
+	Digest string `json:"digest,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+
+	// Notifier runs
+	Notifier string   `json:"notifier,omitempty"`
+	Files    []string `json:"files,omitempty"`
+	Duration float64  `json:"duration_seconds,omitempty"`
+}
+
+// SetError sets the result of the event depending on the error
+func (e *Event) SetError(err error) {
+	if err != nil {
+		e.Result = Failure
+		e.Error = err.Error()
+	} else {
+		e.Result = Success
+	}
+}
+
+// LoadDigestKey reads the key used for digests from a file, generating
+// it if the file doesn't exist
+func LoadDigestKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(key) < DigestKeySize {
+			return nil, fmt.Errorf("digest key in %s is too short", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, DigestKeySize)
+	_, err = rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(key)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return key, f.Close()
+}
+
+// Digest returns the HMAC-SHA256 of some content, a key is used so digests
+// of short secrets cannot be brute-forced
+func Digest(key, content []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return fmt.Sprintf("hmac-sha256:%x", mac.Sum(nil))
+}
+
+// Auditor records events
+type Auditor interface {
+	Audit(Event)
+}
+
+// Log is an auditor that writes events as JSON lines, it is safe to use
+// from multiple goroutines
+type Log struct {
+	lock sync.Mutex
+	w    io.WriteCloser
+}
+
+func New(w io.WriteCloser) *Log {
+	return &Log{w: w}
+}
+
+// Audit writes an event, paths and errors are redacted in case they
+// include any secret value
+func (l *Log) Audit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Path = logging.Redact(e.Path)
+	e.Error = logging.Redact(e.Error)
+	d, err := json.Marshal(e)
+	if err != nil {
+		logging.Errorf("Couldn't encode audit event: %v", err)
+		return
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	_, err = l.w.Write(append(d, '\n'))
+	if err != nil {
+		logging.Errorf("Couldn't write audit event: %v", err)
+	}
+}
+
+func (l *Log) Close() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.w.Close()
+}
+
+// FileWriter appends to a file, rotating it when it reaches its maximum
+// size, rotated files have the number of the rotation as suffix
+type FileWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+// NewFileWriter opens a file to append to it, maximum size is in bytes,
+// files are not rotated if it is zero
+func NewFileWriter(path string, maxSize int64, maxBackups int) (*FileWriter, error) {
+	w := &FileWriter{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	err := w.open()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *FileWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	w.file = file
+	w.size = info.Size()
+	return nil
+}
+
+func (w *FileWriter) Write(p []byte) (int, error) {
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		err := w.rotate()
+		if err != nil {
+			return 0, fmt.Errorf("couldn't rotate %s: %v", w.path, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate renames the current file and the backups, removing the oldest
+// one, and opens a new file
+func (w *FileWriter) rotate() error {
+	err := w.file.Close()
+	if err != nil {
+		return err
+	}
+	if w.maxBackups > 0 {
+		for i := w.maxBackups - 1; i > 0; i-- {
+			err = os.Rename(w.backupPath(i), w.backupPath(i+1))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		err = os.Rename(w.path, w.backupPath(1))
+	} else {
+		err = os.Remove(w.path)
+	}
+	if err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *FileWriter) backupPath(i int) string {
+	return fmt.Sprintf("%s.%d", w.path, i)
+}
+
+func (w *FileWriter) Close() error {
+	return w.file.Close()
+}
+
+var syslogFacilities = map[string]syslog.Priority{
+	"auth":     syslog.LOG_AUTH,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"daemon":   syslog.LOG_DAEMON,
+	"user":     syslog.LOG_USER,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// NewSyslogWriter connects to syslog, to the local daemon if network and
+// address are empty. The authpriv facility is used by default.
+func NewSyslogWriter(network, address, facility, tag string) (io.WriteCloser, error) {
+	priority := syslog.LOG_AUTHPRIV
+	if facility != "" {
+		var found bool
+		priority, found = syslogFacilities[facility]
+		if !found {
+			return nil, fmt.Errorf("unknown syslog facility: %s", facility)
+		}
+	}
+	if tag == "" {
+		tag = DefaultSyslogTag
+	}
+	return syslog.Dial(network, address, priority|syslog.LOG_INFO, tag)
+}
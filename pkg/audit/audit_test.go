@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tuenti/pouch/pkg/logging"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	f, err := os.Open(path)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer f.Close()
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &e))
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-audit")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	logging.SetSecrets([]string{"supersecret"})
+	defer logging.SetSecrets(nil)
+
+	w, err := NewFileWriter(path, 0, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	l := New(w)
+	l.Audit(Event{Type: VaultRequestEvent, Method: "GET", Path: "/v1/foo", Status: 200})
+	e := Event{Type: NotifierEvent, Notifier: "foo", Files: []string{"/tmp/foo"}}
+	e.SetError(errors.New("failed with supersecret"))
+	l.Audit(e)
+	assert.NoError(t, l.Close())
+
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	events := readEvents(t, path)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, VaultRequestEvent, events[0].Type)
+		assert.Equal(t, "/v1/foo", events[0].Path)
+		assert.Equal(t, 200, events[0].Status)
+		assert.False(t, events[0].Time.IsZero())
+
+		assert.Equal(t, Failure, events[1].Result)
+		assert.Equal(t, "failed with "+logging.Redacted, events[1].Error)
+	}
+
+	// Files are appended to
+	w, err = NewFileWriter(path, 0, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	l = New(w)
+	l.Audit(Event{Type: LoginEvent, Result: Success})
+	l.Close()
+	assert.Len(t, readEvents(t, path), 3)
+}
+
+func TestFileWriterRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-audit")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	line := strings.Repeat("x", 9) + "\n"
+	w, err := NewFileWriter(path, 25, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 8; i++ {
+		_, err := w.Write([]byte(line))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, w.Close())
+
+	// Two lines per file, oldest ones are removed
+	for _, p := range []string{path, path + ".1", path + ".2"} {
+		d, err := ioutil.ReadFile(p)
+		if assert.NoError(t, err) {
+			assert.Equal(t, line+line, string(d))
+		}
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLoadDigestKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-audit")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "keys", "audit.key")
+
+	key, err := LoadDigestKey(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, key, DigestKeySize)
+	info, err := os.Stat(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	// Key is kept, so digests don't change between runs
+	loaded, err := LoadDigestKey(path)
+	assert.NoError(t, err)
+	assert.Equal(t, key, loaded)
+
+	digest := Digest(key, []byte("secret"))
+	assert.Equal(t, digest, Digest(loaded, []byte("secret")))
+	assert.NotEqual(t, digest, Digest([]byte("otherkey"), []byte("secret")))
+	assert.True(t, strings.HasPrefix(digest, "hmac-sha256:"))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte("short"), 0600))
+	_, err = LoadDigestKey(path)
+	assert.Error(t, err)
+}
+
+func TestSyslogUnknownFacility(t *testing.T) {
+	_, err := NewSyslogWriter("", "", "unknown", "")
+	assert.Error(t, err)
+}
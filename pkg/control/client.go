@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+)
+
+const (
+	baseURL = "http://pouch"
+
+	// Maximum size of error responses read
+	maxErrorSize = 64 * 1024
+)
+
+// Client of the control API of a running pouch
+type Client struct {
+	client *http.Client
+}
+
+// NewClient returns a client for the control API served in a socket, if
+// empty, DefaultSocket is used
+func NewClient(socket string) *Client {
+	if socket == "" {
+		socket = DefaultSocket
+	}
+	return &Client{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Get requests a path and decodes the response in result
+func (c *Client) Get(path string, result interface{}) error {
+	return c.do(http.MethodGet, path, nil, result)
+}
+
+// Post sends a request as JSON to a path and decodes the response in result
+func (c *Client) Post(path string, request, result interface{}) error {
+	d, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	return c.do(http.MethodPost, path, bytes.NewReader(d), result)
+}
+
+func (c *Client) do(method, path string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequest(method, baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		d, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorSize))
+		if json.Unmarshal(d, &e) == nil && e.Error != "" {
+			return errors.New(e.Error)
+		}
+		return fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-control")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "control.sock")
+
+	l, err := Listen(socket, 0, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/secrets", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(`[{"name": "foo"}]`))
+	})
+	mux.HandleFunc("/v1/refresh", func(w http.ResponseWriter, req *http.Request) {
+		var r map[string]string
+		json.NewDecoder(req.Body).Decode(&r)
+		if r["secret"] != "foo" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": "unknown secret: ` + r["secret"] + `"}`))
+			return
+		}
+		w.Write([]byte(`{"name": "foo"}`))
+	})
+	go http.Serve(l, mux)
+
+	c := NewClient(socket)
+
+	var secrets []map[string]string
+	err = c.Get("/v1/secrets", &secrets)
+	assert.NoError(t, err)
+	assert.Equal(t, []map[string]string{{"name": "foo"}}, secrets)
+
+	var secret map[string]string
+	err = c.Post("/v1/refresh", map[string]string{"secret": "foo"}, &secret)
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", secret["name"])
+
+	err = c.Post("/v1/refresh", map[string]string{"secret": "bar"}, &secret)
+	assert.EqualError(t, err, "unknown secret: bar")
+
+	err = c.Get("/v1/unknown", &secret)
+	assert.EqualError(t, err, "unexpected response: 404 Not Found")
+}
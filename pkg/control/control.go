@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package control implements the transport of the local control API of
+// pouch, served over a Unix socket only available to some users
+package control
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/tuenti/pouch/pkg/logging"
+)
+
+const (
+	DefaultSocket     = "/var/run/pouch/control.sock"
+	DefaultSocketMode = os.FileMode(0600)
+)
+
+// Listen opens the control socket, with the given mode, accepting only
+// connections from processes of the same user as pouch, or of the given
+// UIDs. A previous socket in the same path is removed.
+func Listen(socket string, mode os.FileMode, uids []int) (net.Listener, error) {
+	if socket == "" {
+		socket = DefaultSocket
+	}
+	if mode == 0 {
+		mode = DefaultSocketMode
+	}
+
+	err := os.MkdirAll(filepath.Dir(socket), 0755)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("couldn't remove previous socket: %v", err)
+	}
+
+	// Peer credentials are checked on every connection, so nobody else can
+	// use the socket before its mode is set
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(socket, mode)
+	if err != nil {
+		l.Close()
+		return nil, fmt.Errorf("couldn't set mode of socket: %v", err)
+	}
+
+	allowed := map[uint32]bool{uint32(os.Getuid()): true}
+	for _, uid := range uids {
+		allowed[uint32(uid)] = true
+	}
+	return &peerCredListener{Listener: l, allowed: allowed}, nil
+}
+
+// peerCredListener only accepts connections of allowed users, checking
+// the credentials of their peers
+type peerCredListener struct {
+	net.Listener
+
+	allowed map[uint32]bool
+}
+
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err != nil {
+			logging.WithField(logging.ErrorField, err).Warnf("Rejecting control connection")
+			conn.Close()
+			continue
+		}
+		if !l.allowed[uid] {
+			logging.Warnf("Rejecting control connection from user %d", uid)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+// peerUID returns the UID of the process connected to a Unix socket
+func peerUID(conn net.Conn) (uint32, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, fmt.Errorf("not a unix socket connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if credErr != nil {
+		return 0, fmt.Errorf("couldn't obtain peer credentials: %v", credErr)
+	}
+	return cred.Uid, nil
+}
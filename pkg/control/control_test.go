@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package control
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func serveEcho(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(conn, conn)
+			conn.Close()
+		}()
+	}
+}
+
+func TestListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-control")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "run", "control.sock")
+
+	l, err := Listen(socket, 0660, []int{1000})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+	go serveEcho(l)
+
+	info, err := os.Stat(socket)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0660), info.Mode().Perm())
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestListenRejectsUsers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-control")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "control.sock")
+
+	l, err := Listen(socket, 0, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+
+	// Nobody is allowed, not even the current user
+	l.(*peerCredListener).allowed = map[uint32]bool{}
+	go serveEcho(l)
+
+	conn, err := net.Dial("unix", socket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte("ping"))
+	_, err = conn.Read(make([]byte, 4))
+	assert.Error(t, err, "Connection should be closed")
+}
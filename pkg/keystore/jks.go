@@ -0,0 +1,161 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	jksMagic   = 0xfeedfeed
+	jksVersion = 2
+
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+
+	jksCertType = "X.509"
+
+	// Used by Java to compute the integrity digest of keystores
+	jksDigestWhitener = "Mighty Aphrodite"
+)
+
+// Algorithm used by the proprietary key protector of Sun's JKS implementation
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// TrustedCertificate is an entry of a keystore with a trusted certificate
+type TrustedCertificate struct {
+	Alias       string
+	Certificate *x509.Certificate
+}
+
+type jksWriter struct {
+	bytes.Buffer
+	err error
+}
+
+func (w *jksWriter) write(v interface{}) {
+	if w.err == nil {
+		w.err = binary.Write(&w.Buffer, binary.BigEndian, v)
+	}
+}
+
+func (w *jksWriter) writeUTF(s string) {
+	if len(s) > 0xffff {
+		w.err = fmt.Errorf("string too long: %s", s)
+		return
+	}
+	w.write(uint16(len(s)))
+	w.write([]byte(s))
+}
+
+func (w *jksWriter) writeCertificate(certificate *x509.Certificate) {
+	w.writeUTF(jksCertType)
+	w.write(uint32(len(certificate.Raw)))
+	w.write(certificate.Raw)
+}
+
+// EncodeJKS encodes private key entries and trusted certificates in a Java
+// keystore. Private keys are protected with the same password as the keystore.
+func EncodeJKS(entries []Entry, trusted []TrustedCertificate, password string) ([]byte, error) {
+	timestamp := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+	encodedPassword := bmpString(password)
+
+	var w jksWriter
+	w.write(uint32(jksMagic))
+	w.write(uint32(jksVersion))
+	w.write(uint32(len(entries) + len(trusted)))
+
+	for _, entry := range entries {
+		if len(entry.Chain) == 0 {
+			return nil, fmt.Errorf("no certificate found for private key '%s'", entry.Alias)
+		}
+		key, err := protectJKSKey(entry.PrivateKey, encodedPassword)
+		if err != nil {
+			return nil, err
+		}
+		w.write(uint32(jksPrivateKeyTag))
+		w.writeUTF(entry.Alias)
+		w.write(timestamp)
+		w.write(uint32(len(key)))
+		w.write(key)
+		w.write(uint32(len(entry.Chain)))
+		for _, certificate := range entry.Chain {
+			w.writeCertificate(certificate)
+		}
+	}
+
+	for _, t := range trusted {
+		w.write(uint32(jksTrustedCertTag))
+		w.writeUTF(t.Alias)
+		w.write(timestamp)
+		w.writeCertificate(t.Certificate)
+	}
+
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	h := sha1.New()
+	h.Write(encodedPassword)
+	h.Write([]byte(jksDigestWhitener))
+	h.Write(w.Bytes())
+	w.write(h.Sum(nil))
+	return w.Bytes(), w.err
+}
+
+// protectJKSKey encrypts a private key using the algorithm of Sun's
+// KeyProtector, XORing it with a SHA-1 based keystream
+func protectJKSKey(key interface{}, encodedPassword []byte) ([]byte, error) {
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, sha1.Size)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, 0, len(salt)+len(plain)+sha1.Size)
+	encrypted = append(encrypted, salt...)
+	digest := salt
+	for i := 0; i < len(plain); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte(nil), encodedPassword...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(plain); j++ {
+			encrypted = append(encrypted, plain[i+j]^digest[j])
+		}
+	}
+	checksum := sha1.Sum(append(append([]byte(nil), encodedPassword...), plain...))
+	encrypted = append(encrypted, checksum[:]...)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidJKSKeyProtector,
+			Parameters: asn1.NullRawValue,
+		},
+		EncryptedData: encrypted,
+	})
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package keystore encodes certificates and private keys in the keystore
+// formats used by Java applications.
+package keystore
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Entry with a private key and its certificate chain, first certificate
+// in the chain must be the one of the private key
+type Entry struct {
+	Alias      string
+	PrivateKey crypto.PrivateKey
+	Chain      []*x509.Certificate
+}
+
+// ParsePrivateKey parses a PEM encoded private key in PKCS#1, PKCS#8 or
+// SEC 1 format
+func ParsePrivateKey(data string) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("failed to parse private key PEM")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported private key format: %v", err)
+	}
+	return key, nil
+}
+
+// ParseCertificates parses all PEM encoded certificates in data
+func ParseCertificates(data string) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	rest := []byte(data)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return certificates, nil
+}
+
+// bmpString encodes a string as big-endian UTF-16, as used for passwords
+// and names in keystores
+func bmpString(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	b := make([]byte, 0, 2*len(encoded))
+	for _, c := range encoded {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
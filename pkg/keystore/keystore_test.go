@@ -0,0 +1,323 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEntry(t *testing.T) Entry {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "foo.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Entry{Alias: "foo", PrivateKey: key, Chain: []*x509.Certificate{certificate}}
+}
+
+func TestParse(t *testing.T) {
+	entry := testEntry(t)
+
+	der, err := x509.MarshalECPrivateKey(entry.PrivateKey.(*ecdsa.PrivateKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePrivateKey(string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})))
+	if assert.NoError(t, err) {
+		assert.Equal(t, entry.PrivateKey, key)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: entry.Chain[0].Raw})
+	certificates, err := ParseCertificates(string(certPEM) + string(certPEM))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, len(certificates))
+	}
+
+	_, err = ParseCertificates("")
+	assert.Error(t, err)
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	entry := testEntry(t)
+	password := "secret"
+	encodedPassword := append(bmpString(password), 0, 0)
+
+	d, err := EncodePKCS12(entry, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pfx pfxPdu
+	_, err = asn1.Unmarshal(d, &pfx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, pfx.Version)
+
+	var authenticatedSafeBytes []byte
+	_, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authenticatedSafeBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	macKey := pkcs12KDF(pfx.MacData.MacSalt, encodedPassword, pfx.MacData.Iterations, 3, 20)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(authenticatedSafeBytes)
+	assert.Equal(t, mac.Sum(nil), pfx.MacData.Mac.Digest, "MAC should be valid")
+
+	var authenticatedSafe []contentInfo
+	_, err = asn1.Unmarshal(authenticatedSafeBytes, &authenticatedSafe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !assert.Equal(t, 2, len(authenticatedSafe)) {
+		return
+	}
+
+	var safeContents []byte
+	_, err = asn1.Unmarshal(authenticatedSafe[1].Content.Bytes, &safeContents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bags []safeBag
+	_, err = asn1.Unmarshal(safeContents, &bags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, oidPKCS8ShroudedKeyBag, bags[0].ID)
+
+	var keyInfo encryptedPrivateKeyInfo
+	_, err = asn1.Unmarshal(bags[0].Value.Bytes, &keyInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var params pbeParams
+	_, err = asn1.Unmarshal(keyInfo.Algorithm.Parameters.FullBytes, &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := des.NewTripleDESCipher(pkcs12KDF(params.Salt, encodedPassword, params.Iterations, 1, 24))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iv := pkcs12KDF(params.Salt, encodedPassword, params.Iterations, 2, 8)
+	plain := make([]byte, len(keyInfo.EncryptedData))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, keyInfo.EncryptedData)
+	plain = plain[:len(plain)-int(plain[len(plain)-1])]
+
+	key, err := x509.ParsePKCS8PrivateKey(plain)
+	if assert.NoError(t, err) {
+		assert.Equal(t, entry.PrivateKey, key)
+	}
+}
+
+func TestEncodeJKS(t *testing.T) {
+	entry := testEntry(t)
+	password := "secret"
+	encodedPassword := bmpString(password)
+	trusted := []TrustedCertificate{{Alias: "ca", Certificate: entry.Chain[0]}}
+
+	d, err := EncodeJKS([]Entry{entry}, trusted, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, digest := d[:len(d)-sha1.Size], d[len(d)-sha1.Size:]
+	h := sha1.New()
+	h.Write(encodedPassword)
+	h.Write([]byte(jksDigestWhitener))
+	h.Write(content)
+	assert.Equal(t, h.Sum(nil), digest, "Keystore digest should be valid")
+
+	r := bytes.NewReader(content)
+	var header struct{ Magic, Version, Count, Tag uint32 }
+	binary.Read(r, binary.BigEndian, &header)
+	assert.Equal(t, uint32(jksMagic), header.Magic)
+	assert.Equal(t, uint32(2), header.Count)
+	assert.Equal(t, uint32(jksPrivateKeyTag), header.Tag)
+
+	var aliasLength uint16
+	binary.Read(r, binary.BigEndian, &aliasLength)
+	alias := make([]byte, aliasLength)
+	r.Read(alias)
+	assert.Equal(t, "foo", string(alias))
+
+	var timestamp uint64
+	var keyLength uint32
+	binary.Read(r, binary.BigEndian, &timestamp)
+	binary.Read(r, binary.BigEndian, &keyLength)
+	protected := make([]byte, keyLength)
+	r.Read(protected)
+
+	var keyInfo encryptedPrivateKeyInfo
+	_, err = asn1.Unmarshal(protected, &keyInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, oidJKSKeyProtector, keyInfo.Algorithm.Algorithm)
+
+	encrypted := keyInfo.EncryptedData
+	salt, encrypted := encrypted[:sha1.Size], encrypted[sha1.Size:len(encrypted)-sha1.Size]
+	plain := make([]byte, len(encrypted))
+	digest = salt
+	for i := 0; i < len(encrypted); i += sha1.Size {
+		sum := sha1.Sum(append(append([]byte(nil), encodedPassword...), digest...))
+		digest = sum[:]
+		for j := 0; j < sha1.Size && i+j < len(encrypted); j++ {
+			plain[i+j] = encrypted[i+j] ^ digest[j]
+		}
+	}
+	key, err := x509.ParsePKCS8PrivateKey(plain)
+	if assert.NoError(t, err) {
+		assert.Equal(t, entry.PrivateKey, key)
+	}
+}
+
+// opensslEntry returns an entry with a key and a chain generated by openssl
+func opensslEntry(t *testing.T) Entry {
+	read := func(name string) string {
+		d, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(d)
+	}
+	key, err := ParsePrivateKey(read("openssl-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := ParseCertificates(read("openssl-chain.pem") + read("openssl-ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Entry{Alias: "foo", PrivateKey: key, Chain: chain}
+}
+
+// writeTemp writes data to a temporary file, returning its path and a
+// function to remove it
+func writeTemp(t *testing.T, data []byte) (string, func()) {
+	f, err := ioutil.TempFile("", "pouch-keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f.Name(), func() { os.Remove(f.Name()) }
+}
+
+func TestPKCS12OpenSSL(t *testing.T) {
+	if _, err := exec.LookPath("openssl"); err != nil {
+		t.Skip("openssl not available")
+	}
+	entry := opensslEntry(t)
+	d, err := EncodePKCS12(entry, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, cleanup := writeTemp(t, d)
+	defer cleanup()
+
+	out, err := exec.Command("openssl", "pkcs12", "-in", path, "-passin", "pass:secret", "-nodes").CombinedOutput()
+	if !assert.NoError(t, err, string(out)) {
+		return
+	}
+
+	var certificates [][]byte
+	var key []byte
+	for rest := out; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "CERTIFICATE":
+			certificates = append(certificates, block.Bytes)
+		case "PRIVATE KEY":
+			key = block.Bytes
+		}
+	}
+	if assert.Len(t, certificates, len(entry.Chain)) {
+		for i, c := range entry.Chain {
+			assert.Equal(t, c.Raw, certificates[i], "Certificate %d of the chain", i)
+		}
+	}
+	decoded, err := x509.ParsePKCS8PrivateKey(key)
+	if assert.NoError(t, err) {
+		assert.Equal(t, entry.PrivateKey, decoded)
+	}
+}
+
+func TestJKSKeytool(t *testing.T) {
+	if _, err := exec.LookPath("keytool"); err != nil {
+		t.Skip("keytool not available")
+	}
+	entry := opensslEntry(t)
+	trusted := []TrustedCertificate{{Alias: "ca", Certificate: entry.Chain[len(entry.Chain)-1]}}
+	d, err := EncodeJKS([]Entry{entry}, trusted, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, cleanup := writeTemp(t, d)
+	defer cleanup()
+
+	out, err := exec.Command("keytool", "-list", "-v", "-storetype", "JKS", "-keystore", path, "-storepass", "secret").CombinedOutput()
+	if !assert.NoError(t, err, string(out)) {
+		return
+	}
+	assert.Contains(t, string(out), "Alias name: foo")
+	assert.Contains(t, string(out), "Alias name: ca")
+	assert.Contains(t, string(out), "Certificate chain length: 3")
+	assert.True(t, strings.Contains(string(out), "PrivateKeyEntry"), "Key entry should be listed")
+}
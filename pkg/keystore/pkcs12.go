@@ -0,0 +1,303 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keystore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+const (
+	PKCS12Iterations = 2048
+	pkcs12SaltLength = 8
+)
+
+var (
+	oidData                       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidCertBag                    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidPKCS8ShroudedKeyBag        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertTypeX509               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+	oidFriendlyName               = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID                 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	oidPBEWithSHAAnd3KeyTripleDES = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidSHA1                       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+)
+
+type pfxPdu struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"tag:0,explicit"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue     `asn1:"tag:0,explicit"`
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type pkcs12Attribute struct {
+	ID    asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data []byte `asn1:"tag:0,explicit"`
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// EncodePKCS12 encodes an entry in a PKCS#12 bundle protected by a password.
+// The private key is encrypted with 3DES, as supported by OpenSSL and Java.
+func EncodePKCS12(entry Entry, password string) ([]byte, error) {
+	if len(entry.Chain) == 0 {
+		return nil, fmt.Errorf("no certificate found for private key")
+	}
+	encodedPassword := append(bmpString(password), 0, 0)
+
+	localKeyID := sha1.Sum(entry.Chain[0].Raw)
+	attributes, err := pkcs12Attributes(entry.Alias, localKeyID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for i, certificate := range entry.Chain {
+		bag, err := asn1.Marshal(certBag{ID: oidCertTypeX509, Data: certificate.Raw})
+		if err != nil {
+			return nil, err
+		}
+		safeBag := safeBag{ID: oidCertBag, Value: explicitValue(bag)}
+		if i == 0 {
+			safeBag.Attributes = attributes
+		}
+		certBags = append(certBags, safeBag)
+	}
+
+	shroudedKey, err := encryptPKCS8(entry.PrivateKey, encodedPassword)
+	if err != nil {
+		return nil, err
+	}
+	keyBags := []safeBag{{
+		ID:         oidPKCS8ShroudedKeyBag,
+		Value:      explicitValue(shroudedKey),
+		Attributes: attributes,
+	}}
+
+	var authenticatedSafe []contentInfo
+	for _, bags := range [][]safeBag{certBags, keyBags} {
+		ci, err := dataContentInfo(bags)
+		if err != nil {
+			return nil, err
+		}
+		authenticatedSafe = append(authenticatedSafe, ci)
+	}
+	authenticatedSafeBytes, err := asn1.Marshal(authenticatedSafe)
+	if err != nil {
+		return nil, err
+	}
+
+	macSalt := make([]byte, pkcs12SaltLength)
+	if _, err := rand.Read(macSalt); err != nil {
+		return nil, err
+	}
+	macKey := pkcs12KDF(macSalt, encodedPassword, PKCS12Iterations, 3, 20)
+	mac := hmac.New(sha1.New, macKey)
+	mac.Write(authenticatedSafeBytes)
+
+	content, err := asn1.Marshal(authenticatedSafeBytes)
+	if err != nil {
+		return nil, err
+	}
+	pfx := pfxPdu{
+		Version: 3,
+		AuthSafe: contentInfo{
+			ContentType: oidData,
+			Content:     explicitValue(content),
+		},
+		MacData: macData{
+			Mac: digestInfo{
+				Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA1, Parameters: asn1.NullRawValue},
+				Digest:    mac.Sum(nil),
+			},
+			MacSalt:    macSalt,
+			Iterations: PKCS12Iterations,
+		},
+	}
+	return asn1.Marshal(pfx)
+}
+
+// explicitValue wraps encoded data with an explicit context-specific tag 0,
+// RawValue fields are encoded as is, ignoring tags defined in the field
+func explicitValue(data []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: data}
+}
+
+func pkcs12Attributes(alias string, localKeyID []byte) ([]pkcs12Attribute, error) {
+	keyID, err := asn1.Marshal(localKeyID)
+	if err != nil {
+		return nil, err
+	}
+	attributes := []pkcs12Attribute{{
+		ID:    oidLocalKeyID,
+		Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: keyID},
+	}}
+	if alias != "" {
+		name, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagBMPString, Bytes: bmpString(alias)})
+		if err != nil {
+			return nil, err
+		}
+		attributes = append(attributes, pkcs12Attribute{
+			ID:    oidFriendlyName,
+			Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: name},
+		})
+	}
+	return attributes, nil
+}
+
+func dataContentInfo(bags []safeBag) (contentInfo, error) {
+	safeContents, err := asn1.Marshal(bags)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	content, err := asn1.Marshal(safeContents)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{
+		ContentType: oidData,
+		Content:     explicitValue(content),
+	}, nil
+}
+
+// encryptPKCS8 encrypts a private key with pbeWithSHAAnd3-KeyTripleDES-CBC
+func encryptPKCS8(key interface{}, encodedPassword []byte) ([]byte, error) {
+	plain, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, pkcs12SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: PKCS12Iterations})
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := des.NewTripleDESCipher(pkcs12KDF(salt, encodedPassword, PKCS12Iterations, 1, 24))
+	if err != nil {
+		return nil, err
+	}
+	iv := pkcs12KDF(salt, encodedPassword, PKCS12Iterations, 2, block.BlockSize())
+
+	padding := block.BlockSize() - len(plain)%block.BlockSize()
+	plain = append(plain, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, plain)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  oidPBEWithSHAAnd3KeyTripleDES,
+			Parameters: asn1.RawValue{FullBytes: params},
+		},
+		EncryptedData: encrypted,
+	})
+}
+
+// pkcs12KDF derives keys from passwords using SHA-1 as defined in
+// RFC 7292, appendix B.2
+func pkcs12KDF(salt, password []byte, iterations int, id byte, size int) []byte {
+	const u, v = sha1.Size, 64
+
+	fill := func(b []byte) []byte {
+		if len(b) == 0 {
+			return nil
+		}
+		length := v * ((len(b) + v - 1) / v)
+		filled := make([]byte, length)
+		for i := range filled {
+			filled[i] = b[i%len(b)]
+		}
+		return filled
+	}
+
+	D := bytes.Repeat([]byte{id}, v)
+	I := append(fill(salt), fill(password)...)
+
+	one := big.NewInt(1)
+	var result []byte
+	for len(result) < size {
+		h := sha1.New()
+		h.Write(D)
+		h.Write(I)
+		A := h.Sum(nil)
+		for i := 1; i < iterations; i++ {
+			sum := sha1.Sum(A)
+			A = sum[:]
+		}
+		result = append(result, A...)
+
+		B := new(big.Int).SetBytes(fill(A[:u]))
+		B.Add(B, one)
+		for j := 0; j < len(I); j += v {
+			Ij := new(big.Int).SetBytes(I[j : j+v])
+			Ij.Add(Ij, B)
+			b := Ij.Bytes()
+			if len(b) > v {
+				b = b[len(b)-v:]
+			}
+			copy(I[j:j+v], make([]byte, v))
+			copy(I[j+v-len(b):j+v], b)
+		}
+	}
+	return result[:size]
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging implements a leveled logger with structured fields, that
+// writes as text or as JSON, and redacts secret values before writing
+// anything
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+var levelNames = map[Level]string{
+	DebugLevel: "debug",
+	InfoLevel:  "info",
+	WarnLevel:  "warning",
+	ErrorLevel: "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses the name of a level, info is used if empty
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return DebugLevel, nil
+	case "", "info":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level: %s", name)
+}
+
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+
+	textTimeFormat = "2006/01/02 15:04:05"
+)
+
+// Common fields
+const (
+	SecretField   = "secret"
+	FileField     = "file"
+	NotifierField = "notifier"
+	PathField     = "path"
+	ErrorField    = "error"
+)
+
+const (
+	// Replacement of secret values
+	Redacted = "[REDACTED]"
+
+	// Shorter values are not redacted, as they would probably redact
+	// values that are not secret
+	MinRedactedLength = 4
+)
+
+type Fields map[string]interface{}
+
+// Logger writes log entries with a level and fields, it is safe to use from
+// multiple goroutines
+type Logger struct {
+	lock     sync.Mutex
+	out      io.Writer
+	level    Level
+	format   string
+	redactor *strings.Replacer
+}
+
+func New(out io.Writer) *Logger {
+	return &Logger{out: out, level: InfoLevel, format: TextFormat}
+}
+
+// Default logger, used by the package level functions
+var Default = New(os.Stderr)
+
+func (l *Logger) SetOutput(out io.Writer) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.out = out
+}
+
+func (l *Logger) SetLevel(level Level) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.level = level
+}
+
+// SetFormat sets the output format, text if empty
+func (l *Logger) SetFormat(format string) error {
+	if format == "" {
+		format = TextFormat
+	}
+	if format != TextFormat && format != JSONFormat {
+		return fmt.Errorf("unknown log format: %s", format)
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.format = format
+	return nil
+}
+
+// SetSecrets sets the values to redact, replacing the previous ones. Each
+// line of values with multiple lines is also redacted.
+func (l *Logger) SetSecrets(values []string) {
+	var secrets []string
+	seen := make(map[string]bool)
+	add := func(v string) {
+		if len(v) >= MinRedactedLength && !seen[v] {
+			seen[v] = true
+			secrets = append(secrets, v)
+		}
+	}
+	for _, v := range values {
+		add(v)
+		if strings.Contains(v, "\n") {
+			for _, line := range strings.Split(v, "\n") {
+				add(strings.TrimSpace(line))
+			}
+		}
+	}
+
+	// Longer values first, so values containing others are redacted
+	// completely
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	var pairs []string
+	for _, s := range secrets {
+		pairs = append(pairs, s, Redacted)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.redactor = nil
+	if len(pairs) > 0 {
+		l.redactor = strings.NewReplacer(pairs...)
+	}
+}
+
+// Redact replaces the secret values found in a string
+func (l *Logger) Redact(s string) string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.redact(s)
+}
+
+func (l *Logger) redact(s string) string {
+	if l.redactor == nil {
+		return s
+	}
+	return l.redactor.Replace(s)
+}
+
+func (l *Logger) WithField(key string, value interface{}) *Entry {
+	return &Entry{logger: l, fields: Fields{key: value}}
+}
+
+func (l *Logger) WithFields(fields Fields) *Entry {
+	return (&Entry{logger: l}).WithFields(fields)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.write(DebugLevel, nil, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.write(InfoLevel, nil, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.write(WarnLevel, nil, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.write(ErrorLevel, nil, fmt.Sprintf(format, args...))
+}
+
+// Fatalf logs an error and exits
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.write(ErrorLevel, nil, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l *Logger) write(level Level, fields Fields, msg string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if level < l.level {
+		return
+	}
+
+	now := time.Now()
+	var b bytes.Buffer
+	if l.format == JSONFormat {
+		entry := make(map[string]interface{})
+		for k, v := range fields {
+			switch v := v.(type) {
+			case string:
+				entry[k] = l.redact(v)
+			case error:
+				entry[k] = l.redact(v.Error())
+			case time.Time:
+				entry[k] = v
+			case fmt.Stringer:
+				entry[k] = l.redact(v.String())
+			case bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+				entry[k] = v
+			default:
+				// Values of other types may contain secrets
+				entry[k] = l.redact(fmt.Sprint(v))
+			}
+		}
+		entry["time"] = now.Format(time.RFC3339Nano)
+		entry["level"] = level.String()
+		entry["msg"] = l.redact(msg)
+		err := json.NewEncoder(&b).Encode(entry)
+		if err != nil {
+			fmt.Fprintf(&b, "%s %s %s (couldn't encode entry: %v)\n", now.Format(textTimeFormat), strings.ToUpper(level.String()), l.redact(msg), err)
+		}
+	} else {
+		fmt.Fprintf(&b, "%s %s %s", now.Format(textTimeFormat), strings.ToUpper(level.String()), l.redact(msg))
+		var keys []string
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(&b, " %s=%s", k, quoteValue(l.redact(fmt.Sprint(fields[k]))))
+		}
+		b.WriteByte('\n')
+	}
+	l.out.Write(b.Bytes())
+}
+
+// quoteValue quotes values of fields in text format if needed
+func quoteValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
+
+// Writer returns a writer that logs each write as an entry with the given
+// level, it can be used as output of the standard log package
+func (l *Logger) Writer(level Level) io.Writer {
+	return &logWriter{logger: l, level: level}
+}
+
+type logWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.logger.write(w.level, nil, strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// Entry is a log entry with fields
+type Entry struct {
+	logger *Logger
+	fields Fields
+}
+
+// WithField returns a new entry with an additional field
+func (e *Entry) WithField(key string, value interface{}) *Entry {
+	return e.WithFields(Fields{key: value})
+}
+
+// WithFields returns a new entry with additional fields
+func (e *Entry) WithFields(fields Fields) *Entry {
+	all := make(Fields, len(e.fields)+len(fields))
+	for k, v := range e.fields {
+		all[k] = v
+	}
+	for k, v := range fields {
+		all[k] = v
+	}
+	return &Entry{logger: e.logger, fields: all}
+}
+
+func (e *Entry) Debugf(format string, args ...interface{}) {
+	e.logger.write(DebugLevel, e.fields, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Infof(format string, args ...interface{}) {
+	e.logger.write(InfoLevel, e.fields, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Warnf(format string, args ...interface{}) {
+	e.logger.write(WarnLevel, e.fields, fmt.Sprintf(format, args...))
+}
+
+func (e *Entry) Errorf(format string, args ...interface{}) {
+	e.logger.write(ErrorLevel, e.fields, fmt.Sprintf(format, args...))
+}
+
+func WithField(key string, value interface{}) *Entry {
+	return Default.WithField(key, value)
+}
+
+func WithFields(fields Fields) *Entry {
+	return Default.WithFields(fields)
+}
+
+func Debugf(format string, args ...interface{}) {
+	Default.Debugf(format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	Default.Infof(format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	Default.Warnf(format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	Default.Errorf(format, args...)
+}
+
+func Fatalf(format string, args ...interface{}) {
+	Default.Fatalf(format, args...)
+}
+
+func SetSecrets(values []string) {
+	Default.SetSecrets(values)
+}
+
+func Redact(s string) string {
+	return Default.Redact(s)
+}
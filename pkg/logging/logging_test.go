@@ -0,0 +1,115 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withoutTime removes the date and time of text log lines
+func withoutTime(s string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(s), "\n") {
+		parts := strings.SplitN(line, " ", 3)
+		lines = append(lines, parts[len(parts)-1])
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestTextLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+
+	l.Debugf("not shown")
+	l.Infof("Updating secret")
+	l.WithField(SecretField, "db").WithFields(Fields{FileField: "/etc/db conf", ErrorField: errors.New("failed")}).Errorf("Couldn't write %d bytes", 42)
+	l.SetLevel(WarnLevel)
+	l.Infof("not shown")
+	l.WithField(NotifierField, "nginx").Warnf("Retrying")
+
+	expected := `INFO Updating secret
+ERROR Couldn't write 42 bytes error=failed file="/etc/db conf" secret=db
+WARNING Retrying notifier=nginx`
+	assert.Equal(t, expected, withoutTime(b.String()))
+}
+
+func TestJSONLogger(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	assert.NoError(t, l.SetFormat(JSONFormat))
+	assert.Error(t, l.SetFormat("xml"))
+
+	l.WithFields(Fields{PathField: "/v1/secret/db", "status": 200}).Infof("Vault request")
+
+	var entry map[string]interface{}
+	if assert.NoError(t, json.Unmarshal(b.Bytes(), &entry)) {
+		assert.Equal(t, "info", entry["level"])
+		assert.Equal(t, "Vault request", entry["msg"])
+		assert.Equal(t, "/v1/secret/db", entry["path"])
+		assert.Equal(t, float64(200), entry["status"])
+		assert.NotEmpty(t, entry["time"])
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	l.SetSecrets([]string{"s3cr3t", "s3cr3t-longer", "ab", "-----BEGIN KEY-----\nc2VjcmV0a2V5\n-----END KEY-----\n"})
+
+	l.WithField("output", "password=s3cr3t-longer").Infof("Got s3cr3t and ab")
+	l.Warnf("Partial key: c2VjcmV0a2V5")
+	l.SetSecrets(nil)
+	l.Infof("Old s3cr3t")
+
+	expected := `INFO Got [REDACTED] and ab output="password=[REDACTED]"
+WARNING Partial key: [REDACTED]
+INFO Old s3cr3t`
+	assert.Equal(t, expected, withoutTime(b.String()))
+
+	b.Reset()
+	l.SetFormat(JSONFormat)
+	l.SetSecrets([]string{"s3cr3t"})
+	l.WithFields(Fields{ErrorField: errors.New("token s3cr3t rejected")}).Errorf("Failed")
+	l.WithFields(Fields{"data": map[string]interface{}{"password": "s3cr3t"}, "keys": []string{"s3cr3t"}}).Infof("Nested")
+	assert.NotContains(t, b.String(), "s3cr3t")
+}
+
+func TestStandardLogWriter(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b)
+	l.SetSecrets([]string{"s3cr3t"})
+	std := log.New(l.Writer(InfoLevel), "", 0)
+	std.Printf("Using s3cr3t")
+	assert.Equal(t, "INFO Using [REDACTED]", withoutTime(b.String()))
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"": InfoLevel, "debug": DebugLevel, "WARN": WarnLevel, "warning": WarnLevel, "error": ErrorLevel} {
+		level, err := ParseLevel(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, level)
+	}
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err)
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics collects metrics with labels and exposes them in the
+// Prometheus text format. Values are kept as float64, so timestamps can be
+// exported without losing precision.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	counterType = "counter"
+	gaugeType   = "gauge"
+	summaryType = "summary"
+
+	contentType = "text/plain; version=0.0.4"
+)
+
+// Labels of a metric
+type Labels map[string]string
+
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	var names []string
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabelValue(l[name])))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
+
+type series struct {
+	value float64
+
+	// Number of observations, for summaries
+	count uint64
+}
+
+type family struct {
+	kind   string
+	series map[string]*series
+}
+
+// Registry holds the current value of a set of metrics, it is safe to use
+// from multiple goroutines
+type Registry struct {
+	lock     sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Default registry, used by the package level functions
+var Default = NewRegistry()
+
+// get returns the series of a metric, creating it if needed, the registry
+// must be locked
+func (r *Registry) get(kind, name string, labels Labels) *series {
+	f, found := r.families[name]
+	if !found {
+		f = &family{kind: kind, series: make(map[string]*series)}
+		r.families[name] = f
+	}
+	key := labels.String()
+	s, found := f.series[key]
+	if !found {
+		s = &series{}
+		f.series[key] = s
+	}
+	return s
+}
+
+// SetGauge sets the value of a gauge
+func (r *Registry) SetGauge(name string, labels Labels, value float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.get(gaugeType, name, labels).value = value
+}
+
+// SetTimestamp sets a gauge to a time, in seconds since the epoch
+func (r *Registry) SetTimestamp(name string, labels Labels, t time.Time) {
+	r.SetGauge(name, labels, float64(t.UnixNano())/float64(time.Second))
+}
+
+// IncrCounter adds a value to a counter
+func (r *Registry) IncrCounter(name string, labels Labels, value float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.get(counterType, name, labels).value += value
+}
+
+// Observe adds an observation to a summary, that exposes its sum and count
+func (r *Registry) Observe(name string, labels Labels, value float64) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s := r.get(summaryType, name, labels)
+	s.value += value
+	s.count++
+}
+
+// Delete removes a metric with the given labels
+func (r *Registry) Delete(name string, labels Labels) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if f, found := r.families[name]; found {
+		delete(f.series, labels.String())
+	}
+}
+
+// WriteTo writes all metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	r.lock.Lock()
+	var names []string
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r.families[name].write(&b, name)
+	}
+	r.lock.Unlock()
+	return b.WriteTo(w)
+}
+
+func (f *family) write(b *bytes.Buffer, name string) {
+	if len(f.series) == 0 {
+		return
+	}
+	var keys []string
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fmt.Fprintf(b, "# TYPE %s %s\n", name, f.kind)
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind == summaryType {
+			fmt.Fprintf(b, "%s_sum%s %s\n", name, key, formatValue(s.value))
+			fmt.Fprintf(b, "%s_count%s %d\n", name, key, s.count)
+			continue
+		}
+		fmt.Fprintf(b, "%s%s %s\n", name, key, formatValue(s.value))
+	}
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ServeHTTP exposes the metrics of the registry
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	r.WriteTo(w)
+}
+
+func SetGauge(name string, labels Labels, value float64) {
+	Default.SetGauge(name, labels, value)
+}
+
+func SetTimestamp(name string, labels Labels, t time.Time) {
+	Default.SetTimestamp(name, labels, t)
+}
+
+func IncrCounter(name string, labels Labels, value float64) {
+	Default.IncrCounter(name, labels, value)
+}
+
+func Observe(name string, labels Labels, value float64) {
+	Default.Observe(name, labels, value)
+}
+
+// ObserveSince adds to a summary the seconds passed since a time
+func ObserveSince(name string, labels Labels, start time.Time) {
+	Default.Observe(name, labels, time.Since(start).Seconds())
+}
+
+func Delete(name string, labels Labels) {
+	Default.Delete(name, labels)
+}
+
+// Listen opens a listener for the metrics endpoint, on a TCP address, or
+// on a Unix socket if socket is set. A previous socket in the same path
+// is removed.
+func Listen(address, socket string) (net.Listener, error) {
+	switch {
+	case address != "" && socket != "":
+		return nil, fmt.Errorf("only one of address or socket can be set")
+	case socket != "":
+		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("couldn't remove previous socket: %v", err)
+		}
+		return net.Listen("unix", socket)
+	case address != "":
+		return net.Listen("tcp", address)
+	}
+	return nil, fmt.Errorf("address or socket needed")
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryWrite(t *testing.T) {
+	r := NewRegistry()
+	r.SetGauge("pouch_secret_ttu", Labels{"secret": "db"}, 42)
+	r.SetGauge("pouch_secret_ttu", Labels{"secret": "cert"}, 1.5)
+	r.SetTimestamp("pouch_file_last_write", Labels{"file": `/etc/"a"\b`}, time.Unix(1536000000, 500000000))
+	r.IncrCounter("pouch_notifier_runs_total", Labels{"notifier": "nginx", "result": "ok"}, 1)
+	r.IncrCounter("pouch_notifier_runs_total", Labels{"result": "ok", "notifier": "nginx"}, 1)
+	r.Observe("pouch_notifier_duration_seconds", Labels{"notifier": "nginx"}, 0.5)
+	r.Observe("pouch_notifier_duration_seconds", Labels{"notifier": "nginx"}, 1.25)
+	r.SetGauge("pouch_token_ttl_seconds", nil, 3600)
+	r.SetGauge("pouch_deleted", Labels{"secret": "old"}, 1)
+	r.Delete("pouch_deleted", Labels{"secret": "old"})
+
+	var b bytes.Buffer
+	_, err := r.WriteTo(&b)
+	assert.NoError(t, err)
+
+	expected := `# TYPE pouch_file_last_write gauge
+pouch_file_last_write{file="/etc/\"a\"\\b"} 1.5360000005e+09
+# TYPE pouch_notifier_duration_seconds summary
+pouch_notifier_duration_seconds_sum{notifier="nginx"} 1.75
+pouch_notifier_duration_seconds_count{notifier="nginx"} 2
+# TYPE pouch_notifier_runs_total counter
+pouch_notifier_runs_total{notifier="nginx",result="ok"} 2
+# TYPE pouch_secret_ttu gauge
+pouch_secret_ttu{secret="cert"} 1.5
+pouch_secret_ttu{secret="db"} 42
+# TYPE pouch_token_ttl_seconds gauge
+pouch_token_ttl_seconds 3600
+`
+	assert.Equal(t, expected, b.String())
+}
+
+func TestListen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-metrics")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "metrics.sock")
+
+	// A stale socket doesn't prevent listening again
+	stale, err := Listen("", socket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := Listen("", socket)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+
+	r := NewRegistry()
+	r.IncrCounter("pouch_test_total", nil, 3)
+	go http.Serve(l, r)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(_, _ string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+	}
+	resp, err := client.Get("http://pouch/metrics")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	d, _ := ioutil.ReadAll(resp.Body)
+	assert.Equal(t, contentType, resp.Header.Get("Content-Type"))
+	assert.Equal(t, "# TYPE pouch_test_total counter\npouch_test_total 3\n", string(d))
+
+	_, err = Listen("127.0.0.1:0", socket)
+	assert.Error(t, err)
+	_, err = Listen("", "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package openrc controls services managed by OpenRC, using rc-service
+package openrc
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	RCService = "rc-service"
+
+	statusPollInterval = 500 * time.Millisecond
+)
+
+// Actions supported on OpenRC services, signals cannot be sent to them
+var Actions = []string{"reload", "restart", "try-restart", "reload-or-restart", "reload-or-try-restart", "start"}
+
+type OpenRC interface {
+	Reload(context.Context, string) error
+	ServiceAction(context.Context, string, string, syscall.Signal) error
+	WaitActive(context.Context, string) error
+}
+
+func New() OpenRC {
+	return &openrc{command: RCService}
+}
+
+type openrc struct {
+	command string
+}
+
+func (o *openrc) run(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, o.command, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", o.command, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Reload reloads the service, or restarts it if it cannot be reloaded
+func (o *openrc) Reload(ctx context.Context, name string) error {
+	return o.ServiceAction(ctx, name, "reload-or-restart", 0)
+}
+
+func (o *openrc) ServiceAction(ctx context.Context, name, action string, signal syscall.Signal) error {
+	switch action {
+	case "reload", "restart", "start":
+		return o.run(ctx, name, action)
+	case "try-restart":
+		return o.run(ctx, "--ifstarted", name, "restart")
+	case "reload-or-restart", "reload-or-try-restart":
+		if err := o.run(ctx, "--ifstarted", name, "reload"); err == nil {
+			return nil
+		}
+		if action == "reload-or-try-restart" {
+			return o.run(ctx, "--ifstarted", name, "restart")
+		}
+		return o.run(ctx, name, "restart")
+	}
+	return fmt.Errorf("unsupported action for OpenRC services: %s", action)
+}
+
+// WaitActive waits till the service status is started
+func (o *openrc) WaitActive(ctx context.Context, name string) error {
+	for {
+		err := o.run(ctx, name, "status")
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("service %s is not started: %v", name, err)
+		case <-time.After(statusPollInterval):
+		}
+	}
+}
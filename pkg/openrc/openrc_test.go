@@ -0,0 +1,79 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package openrc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCommand writes a script that logs its arguments, and fails when
+// reloading the broken service
+func fakeCommand(t *testing.T, dir string) (command, log string) {
+	command = filepath.Join(dir, RCService)
+	log = filepath.Join(dir, "log")
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\ncase \"$*\" in *broken*reload) exit 1;; esac\n"
+	if err := ioutil.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestServiceAction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-openrc")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	command, log := fakeCommand(t, dir)
+	o := &openrc{command: command}
+
+	cases := []struct {
+		service string
+		action  string
+		args    []string
+	}{
+		{"nginx", "reload", []string{"nginx reload"}},
+		{"nginx", "restart", []string{"nginx restart"}},
+		{"nginx", "start", []string{"nginx start"}},
+		{"nginx", "try-restart", []string{"--ifstarted nginx restart"}},
+		{"nginx", "reload-or-restart", []string{"--ifstarted nginx reload"}},
+		{"nginx", "reload-or-try-restart", []string{"--ifstarted nginx reload"}},
+		{"broken", "reload-or-restart", []string{"--ifstarted broken reload", "broken restart"}},
+		{"broken", "reload-or-try-restart", []string{"--ifstarted broken reload", "--ifstarted broken restart"}},
+	}
+	for _, c := range cases {
+		os.Remove(log)
+		assert.NoError(t, o.ServiceAction(context.Background(), c.service, c.action, 0), c.action)
+		d, err := ioutil.ReadFile(log)
+		if assert.NoError(t, err) {
+			assert.Equal(t, c.args, strings.Split(strings.TrimSpace(string(d)), "\n"), c.action)
+		}
+	}
+
+	for _, action := range Actions {
+		assert.NotEqual(t, "kill", action, "Signals cannot be sent to OpenRC services")
+	}
+	assert.Error(t, o.ServiceAction(context.Background(), "nginx", "kill", syscall.SIGHUP))
+}
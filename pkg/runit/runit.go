@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runit controls services supervised by runit, using sv
+package runit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	SV = "sv"
+
+	// Environment variable with the services directory used by sv
+	ServiceDirVar = "SVDIR"
+)
+
+// Actions supported on runit services
+var Actions = []string{"reload", "restart", "try-restart", "reload-or-restart", "reload-or-try-restart", "start", "kill"}
+
+// Commands of sv to send signals to services
+var signalCommands = map[syscall.Signal]string{
+	syscall.SIGHUP:  "hup",
+	syscall.SIGALRM: "alarm",
+	syscall.SIGINT:  "interrupt",
+	syscall.SIGQUIT: "quit",
+	syscall.SIGUSR1: "1",
+	syscall.SIGUSR2: "2",
+	syscall.SIGTERM: "term",
+	syscall.SIGKILL: "kill",
+}
+
+type Runit interface {
+	Reload(context.Context, string) error
+	ServiceAction(context.Context, string, string, syscall.Signal) error
+	WaitActive(context.Context, string) error
+}
+
+// New returns a controller for runit services, if serviceDir is empty,
+// sv default is used
+func New(serviceDir string) Runit {
+	return &runit{command: SV, serviceDir: serviceDir}
+}
+
+type runit struct {
+	command    string
+	serviceDir string
+}
+
+func (r *runit) run(ctx context.Context, args ...string) error {
+	_, err := r.output(ctx, args...)
+	return err
+}
+
+func (r *runit) output(ctx context.Context, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, r.command, args...)
+	if r.serviceDir != "" {
+		cmd.Env = append(os.Environ(), ServiceDirVar+"="+r.serviceDir)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s %s failed: %v: %s", r.command, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// running returns true if the service is up
+func (r *runit) running(ctx context.Context, name string) (bool, error) {
+	out, err := r.output(ctx, "status", name)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(out, "run:"), nil
+}
+
+// Reload sends a SIGHUP to the service, as runit services don't have a
+// reload command
+func (r *runit) Reload(ctx context.Context, name string) error {
+	return r.ServiceAction(ctx, name, "reload", 0)
+}
+
+func (r *runit) ServiceAction(ctx context.Context, name, action string, signal syscall.Signal) error {
+	switch action {
+	case "reload", "reload-or-try-restart":
+		// Signals are only delivered if the service is up
+		return r.run(ctx, "reload", name)
+	case "reload-or-restart":
+		running, err := r.running(ctx, name)
+		if err != nil {
+			return err
+		}
+		if !running {
+			return r.run(ctx, "restart", name)
+		}
+		return r.run(ctx, "reload", name)
+	case "restart", "try-restart", "start":
+		return r.run(ctx, action, name)
+	case "kill":
+		command, found := signalCommands[signal]
+		if !found {
+			return fmt.Errorf("signal %s cannot be sent to runit services", signal)
+		}
+		return r.run(ctx, command, name)
+	}
+	return fmt.Errorf("unsupported action for runit services: %s", action)
+}
+
+// WaitActive waits till the service is up, and its check script succeeds
+// if it has one
+func (r *runit) WaitActive(ctx context.Context, name string) error {
+	timeout := 7
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = int(time.Until(deadline).Seconds())
+		if timeout < 1 {
+			timeout = 1
+		}
+	}
+	return r.run(ctx, "-w", strconv.Itoa(timeout), "check", name)
+}
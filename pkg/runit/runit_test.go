@@ -0,0 +1,85 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCommand writes a script that logs its arguments and the services
+// directory, the stopped service is reported as down
+func fakeCommand(t *testing.T, dir string) (command, log string) {
+	command = filepath.Join(dir, SV)
+	log = filepath.Join(dir, "log")
+	script := "#!/bin/sh\necho \"$" + ServiceDirVar + " $@\" >> " + log + "\n" +
+		"case \"$*\" in \"status stopped\") echo \"down: stopped: 1s\";; status*) echo \"run: $2: (pid 42) 1s\";; esac\n"
+	if err := ioutil.WriteFile(command, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestServiceAction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-runit")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	command, log := fakeCommand(t, dir)
+	r := &runit{command: command, serviceDir: "/etc/service"}
+
+	cases := []struct {
+		service string
+		action  string
+		signal  syscall.Signal
+		args    []string
+	}{
+		{"nginx", "reload", 0, []string{"reload nginx"}},
+		{"nginx", "reload-or-restart", 0, []string{"status nginx", "reload nginx"}},
+		{"stopped", "reload-or-restart", 0, []string{"status stopped", "restart stopped"}},
+		{"nginx", "reload-or-try-restart", 0, []string{"reload nginx"}},
+		{"nginx", "restart", 0, []string{"restart nginx"}},
+		{"nginx", "try-restart", 0, []string{"try-restart nginx"}},
+		{"nginx", "start", 0, []string{"start nginx"}},
+		{"nginx", "kill", syscall.SIGUSR1, []string{"1 nginx"}},
+		{"nginx", "kill", syscall.SIGTERM, []string{"term nginx"}},
+	}
+	for _, c := range cases {
+		os.Remove(log)
+		assert.NoError(t, r.ServiceAction(context.Background(), c.service, c.action, c.signal), c.action)
+		d, err := ioutil.ReadFile(log)
+		if assert.NoError(t, err) {
+			var args []string
+			for _, line := range strings.Split(strings.TrimSpace(string(d)), "\n") {
+				assert.True(t, strings.HasPrefix(line, "/etc/service "), "Services directory should be set")
+				args = append(args, strings.TrimPrefix(line, "/etc/service "))
+			}
+			assert.Equal(t, c.args, args, c.action)
+		}
+	}
+
+	assert.Error(t, r.ServiceAction(context.Background(), "nginx", "kill", syscall.SIGWINCH))
+	assert.Error(t, r.ServiceAction(context.Background(), "nginx", "stop", 0))
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s6 controls services supervised by s6, using s6-svc
+package s6
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	S6Svc    = "s6-svc"
+	S6Svwait = "s6-svwait"
+
+	// Scan directory used by s6-overlay, a common setup in containers
+	DefaultScanDir = "/var/run/s6/services"
+)
+
+// Actions supported on s6 services
+var Actions = []string{"reload", "restart", "try-restart", "reload-or-restart", "reload-or-try-restart", "start", "kill"}
+
+// Options of s6-svc to send signals to services
+var signalOptions = map[syscall.Signal]string{
+	syscall.SIGALRM:  "-a",
+	syscall.SIGABRT:  "-b",
+	syscall.SIGQUIT:  "-q",
+	syscall.SIGHUP:   "-h",
+	syscall.SIGKILL:  "-k",
+	syscall.SIGTERM:  "-t",
+	syscall.SIGINT:   "-i",
+	syscall.SIGUSR1:  "-1",
+	syscall.SIGUSR2:  "-2",
+	syscall.SIGSTOP:  "-p",
+	syscall.SIGCONT:  "-c",
+	syscall.SIGWINCH: "-y",
+}
+
+type S6 interface {
+	Reload(context.Context, string) error
+	ServiceAction(context.Context, string, string, syscall.Signal) error
+	WaitActive(context.Context, string) error
+}
+
+// New returns a controller for services in a s6 scan directory, if
+// scanDir is empty, DefaultScanDir is used
+func New(scanDir string) S6 {
+	if scanDir == "" {
+		scanDir = DefaultScanDir
+	}
+	return &s6{scanDir: scanDir}
+}
+
+type s6 struct {
+	scanDir string
+}
+
+func (s *s6) serviceDir(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.scanDir, name)
+}
+
+func run(ctx context.Context, command string, args ...string) error {
+	out, err := exec.CommandContext(ctx, command, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s failed: %v: %s", command, strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Reload sends a SIGHUP to the service, as s6 services don't have a
+// reload command
+func (s *s6) Reload(ctx context.Context, name string) error {
+	return s.ServiceAction(ctx, name, "reload", 0)
+}
+
+func (s *s6) ServiceAction(ctx context.Context, name, action string, signal syscall.Signal) error {
+	dir := s.serviceDir(name)
+	switch action {
+	case "reload", "reload-or-restart", "reload-or-try-restart":
+		// Signals are only delivered if the service is up
+		return run(ctx, S6Svc, "-h", dir)
+	case "restart":
+		// Terminate the service and ensure it is up again
+		return run(ctx, S6Svc, "-t", "-u", dir)
+	case "try-restart":
+		// Restarts the service only if it is up
+		return run(ctx, S6Svc, "-r", dir)
+	case "start":
+		return run(ctx, S6Svc, "-u", dir)
+	case "kill":
+		option, found := signalOptions[signal]
+		if !found {
+			return fmt.Errorf("signal %s cannot be sent to s6 services", signal)
+		}
+		return run(ctx, S6Svc, option, dir)
+	}
+	return fmt.Errorf("unsupported action for s6 services: %s", action)
+}
+
+// WaitActive waits till the service is up
+func (s *s6) WaitActive(ctx context.Context, name string) error {
+	args := []string{"-u"}
+	if deadline, ok := ctx.Deadline(); ok {
+		args = append(args, "-t", strconv.FormatInt(int64(time.Until(deadline)/time.Millisecond), 10))
+	}
+	return run(ctx, S6Svwait, append(args, s.serviceDir(name))...)
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s6
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCommand writes a script that logs its arguments, and adds it to the
+// path
+func fakeCommand(t *testing.T, dir, name string) (log string) {
+	log = filepath.Join(dir, "log")
+	script := "#!/bin/sh\necho \"$@\" >> " + log + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestServiceAction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-s6")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	log := fakeCommand(t, dir, S6Svc)
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	s := New("/run/service")
+
+	cases := []struct {
+		service string
+		action  string
+		signal  syscall.Signal
+		args    string
+	}{
+		{"nginx", "reload", 0, "-h /run/service/nginx"},
+		{"nginx", "reload-or-restart", 0, "-h /run/service/nginx"},
+		{"nginx", "reload-or-try-restart", 0, "-h /run/service/nginx"},
+		{"nginx", "restart", 0, "-t -u /run/service/nginx"},
+		{"nginx", "try-restart", 0, "-r /run/service/nginx"},
+		{"nginx", "start", 0, "-u /run/service/nginx"},
+		{"nginx", "kill", syscall.SIGUSR2, "-2 /run/service/nginx"},
+		{"/srv/nginx", "kill", syscall.SIGKILL, "-k /srv/nginx"},
+	}
+	for _, c := range cases {
+		os.Remove(log)
+		assert.NoError(t, s.ServiceAction(context.Background(), c.service, c.action, c.signal), c.action)
+		d, err := ioutil.ReadFile(log)
+		if assert.NoError(t, err) {
+			assert.Equal(t, c.args, strings.TrimSpace(string(d)), c.action)
+		}
+	}
+
+	assert.Error(t, s.ServiceAction(context.Background(), "nginx", "kill", syscall.SIGSEGV))
+	assert.Error(t, s.ServiceAction(context.Background(), "nginx", "stop", 0))
+}
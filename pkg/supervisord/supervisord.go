@@ -0,0 +1,204 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package supervisord controls processes managed by supervisord, using
+// its XML-RPC API over its Unix socket
+package supervisord
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	DefaultSocket = "/var/run/supervisor.sock"
+
+	rpcURL             = "http://supervisord/RPC2"
+	statePollInterval  = 500 * time.Millisecond
+	runningState       = "RUNNING"
+	notRunningFaultMsg = "NOT_RUNNING"
+)
+
+// Actions supported on supervisord processes
+var Actions = []string{"reload", "restart", "try-restart", "reload-or-restart", "reload-or-try-restart", "start", "kill"}
+
+// States in which a process won't get to running without intervention
+var failedStates = map[string]bool{
+	"FATAL":   true,
+	"EXITED":  true,
+	"STOPPED": true,
+	"UNKNOWN": true,
+}
+
+// Names of signals as accepted by supervisord
+var signalNames = map[syscall.Signal]string{
+	syscall.SIGHUP:   "HUP",
+	syscall.SIGINT:   "INT",
+	syscall.SIGQUIT:  "QUIT",
+	syscall.SIGKILL:  "KILL",
+	syscall.SIGUSR1:  "USR1",
+	syscall.SIGUSR2:  "USR2",
+	syscall.SIGTERM:  "TERM",
+	syscall.SIGCONT:  "CONT",
+	syscall.SIGSTOP:  "STOP",
+	syscall.SIGWINCH: "WINCH",
+}
+
+type Supervisord interface {
+	Reload(context.Context, string) error
+	ServiceAction(context.Context, string, string, syscall.Signal) error
+	WaitActive(context.Context, string) error
+}
+
+// New returns a controller for processes of supervisord listening in the
+// given socket, if empty, DefaultSocket is used
+func New(socket string) Supervisord {
+	if socket == "" {
+		socket = DefaultSocket
+	}
+	return &supervisord{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+type supervisord struct {
+	client *http.Client
+}
+
+func (s *supervisord) call(ctx context.Context, method string, params ...interface{}) (interface{}, error) {
+	body, err := encodeCall(method, params...)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", rpcURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "text/xml")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	d, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("supervisord returned %s", resp.Status)
+	}
+	result, err := decodeResponse(d)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", method, err)
+	}
+	return result, nil
+}
+
+// Reload restarts the process, as supervisord doesn't support reloads
+func (s *supervisord) Reload(ctx context.Context, name string) error {
+	return s.ServiceAction(ctx, name, "restart", 0)
+}
+
+func (s *supervisord) ServiceAction(ctx context.Context, name, action string, signal syscall.Signal) error {
+	switch action {
+	case "reload", "restart", "reload-or-restart":
+		return s.restart(ctx, name)
+	case "try-restart", "reload-or-try-restart":
+		running, err := s.running(ctx, name)
+		if err != nil || !running {
+			return err
+		}
+		return s.restart(ctx, name)
+	case "start":
+		return s.start(ctx, name)
+	case "kill":
+		signalName, found := signalNames[signal]
+		if !found {
+			return fmt.Errorf("signal %s cannot be sent to supervisord processes", signal)
+		}
+		_, err := s.call(ctx, "supervisor.signalProcess", name, signalName)
+		return err
+	}
+	return fmt.Errorf("unsupported action for supervisord processes: %s", action)
+}
+
+func (s *supervisord) restart(ctx context.Context, name string) error {
+	_, err := s.call(ctx, "supervisor.stopProcess", name, true)
+	if fault, ok := err.(*Fault); ok && strings.Contains(fault.String, notRunningFaultMsg) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.start(ctx, name)
+}
+
+func (s *supervisord) start(ctx context.Context, name string) error {
+	_, err := s.call(ctx, "supervisor.startProcess", name, true)
+	return err
+}
+
+func (s *supervisord) state(ctx context.Context, name string) (string, error) {
+	result, err := s.call(ctx, "supervisor.getProcessInfo", name)
+	if err != nil {
+		return "", err
+	}
+	info, _ := result.(map[string]interface{})
+	state, _ := info["statename"].(string)
+	return state, nil
+}
+
+func (s *supervisord) running(ctx context.Context, name string) (bool, error) {
+	state, err := s.state(ctx, name)
+	return state == runningState, err
+}
+
+// WaitActive waits till the process is running
+func (s *supervisord) WaitActive(ctx context.Context, name string) error {
+	for {
+		state, err := s.state(ctx, name)
+		if err != nil {
+			return err
+		}
+		if state == runningState {
+			return nil
+		}
+		if failedStates[state] {
+			return fmt.Errorf("process %s is not running (found: %s)", name, state)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("process %s is not running (found: %s): %v", name, state, ctx.Err())
+		case <-time.After(statePollInterval):
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package supervisord
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeCall(t *testing.T) {
+	d, err := encodeCall("supervisor.stopProcess", "a<b", true, 3)
+	if assert.NoError(t, err) {
+		assert.Equal(t, `<?xml version="1.0"?><methodCall><methodName>supervisor.stopProcess</methodName><params>`+
+			`<param><value><string>a&lt;b</string></value></param>`+
+			`<param><value><boolean>1</boolean></value></param>`+
+			`<param><value><int>3</int></value></param>`+
+			`</params></methodCall>`, string(d))
+	}
+}
+
+var decodeResponseCases = []struct {
+	Response string
+	Result   interface{}
+	Fault    *Fault
+}{
+	{
+		Response: `<?xml version="1.0"?><methodResponse><params><param><value><boolean>1</boolean></value></param></params></methodResponse>`,
+		Result:   true,
+	},
+	{
+		Response: `<?xml version="1.0"?><methodResponse><params><param><value><struct>
+			<member><name>name</name><value><string>nginx</string></value></member>
+			<member><name>pid</name><value><int>42</int></value></member>
+			<member><name>group</name><value>web</value></member>
+			<member><name>args</name><value><array><data><value><i4>1</i4></value></data></array></value></member>
+			</struct></value></param></params></methodResponse>`,
+		Result: map[string]interface{}{"name": "nginx", "pid": 42, "group": "web", "args": []interface{}{1}},
+	},
+	{
+		Response: `<?xml version="1.0"?><methodResponse><fault><value><struct>
+			<member><name>faultCode</name><value><int>10</int></value></member>
+			<member><name>faultString</name><value><string>BAD_NAME: foo</string></value></member>
+			</struct></value></fault></methodResponse>`,
+		Fault: &Fault{Code: 10, String: "BAD_NAME: foo"},
+	},
+}
+
+func TestDecodeResponse(t *testing.T) {
+	for _, c := range decodeResponseCases {
+		result, err := decodeResponse([]byte(c.Response))
+		if c.Fault != nil {
+			assert.Equal(t, c.Fault, err)
+			continue
+		}
+		if assert.NoError(t, err) {
+			assert.Equal(t, c.Result, result)
+		}
+	}
+}
+
+type dummySupervisord struct {
+	Calls []string
+	State string
+}
+
+func (d *dummySupervisord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var call struct {
+		Method string   `xml:"methodName"`
+		Params []string `xml:"params>param>value>string"`
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	xml.Unmarshal(body, &call)
+	d.Calls = append(d.Calls, fmt.Sprint(call.Method, call.Params))
+
+	result := `<boolean>1</boolean>`
+	if call.Method == "supervisor.getProcessInfo" {
+		result = fmt.Sprintf(`<struct><member><name>statename</name><value><string>%s</string></value></member></struct>`, d.State)
+	}
+	fmt.Fprintf(w, `<?xml version="1.0"?><methodResponse><params><param><value>%s</value></param></params></methodResponse>`, result)
+}
+
+func TestSupervisord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-test-supervisord")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "supervisor.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dummy := &dummySupervisord{State: "RUNNING"}
+	server := &http.Server{Handler: dummy}
+	go server.Serve(l)
+	defer server.Close()
+
+	s := New(socket)
+	ctx := context.Background()
+	assert.NoError(t, s.Reload(ctx, "nginx"))
+	assert.NoError(t, s.ServiceAction(ctx, "nginx", "kill", syscall.SIGUSR1))
+	assert.NoError(t, s.WaitActive(ctx, "nginx"))
+	assert.Equal(t, []string{
+		"supervisor.stopProcess[nginx]",
+		"supervisor.startProcess[nginx]",
+		"supervisor.signalProcess[nginx USR1]",
+		"supervisor.getProcessInfo[nginx]",
+	}, dummy.Calls)
+
+	dummy.Calls = nil
+	dummy.State = "STOPPED"
+	assert.NoError(t, s.ServiceAction(ctx, "nginx", "try-restart", 0))
+	assert.Equal(t, []string{"supervisor.getProcessInfo[nginx]"}, dummy.Calls, "Stopped processes shouldn't be restarted")
+	assert.Error(t, s.WaitActive(ctx, "nginx"))
+}
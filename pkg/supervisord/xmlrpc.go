@@ -0,0 +1,145 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package supervisord
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Minimal XML-RPC encoding, enough for the supervisord API
+
+type xmlrpcValue struct {
+	String  *string       `xml:"string"`
+	Int     *string       `xml:"int"`
+	I4      *string       `xml:"i4"`
+	Boolean *string       `xml:"boolean"`
+	Double  *string       `xml:"double"`
+	Struct  *xmlrpcStruct `xml:"struct"`
+	Array   *xmlrpcArray  `xml:"array"`
+	Text    string        `xml:",chardata"`
+}
+
+type xmlrpcStruct struct {
+	Members []struct {
+		Name  string      `xml:"name"`
+		Value xmlrpcValue `xml:"value"`
+	} `xml:"member"`
+}
+
+type xmlrpcArray struct {
+	Values []xmlrpcValue `xml:"data>value"`
+}
+
+type xmlrpcResponse struct {
+	Params []xmlrpcValue `xml:"params>param>value"`
+	Fault  *xmlrpcValue  `xml:"fault>value"`
+}
+
+// Fault is an error returned by an XML-RPC call
+type Fault struct {
+	Code   int
+	String string
+}
+
+func (f *Fault) Error() string {
+	return fmt.Sprintf("%s (%d)", f.String, f.Code)
+}
+
+func encodeCall(method string, params ...interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0"?><methodCall><methodName>`)
+	xml.EscapeText(&b, []byte(method))
+	b.WriteString(`</methodName><params>`)
+	for _, param := range params {
+		b.WriteString(`<param><value>`)
+		switch v := param.(type) {
+		case string:
+			b.WriteString(`<string>`)
+			xml.EscapeText(&b, []byte(v))
+			b.WriteString(`</string>`)
+		case int:
+			fmt.Fprintf(&b, `<int>%d</int>`, v)
+		case bool:
+			value := 0
+			if v {
+				value = 1
+			}
+			fmt.Fprintf(&b, `<boolean>%d</boolean>`, value)
+		default:
+			return nil, fmt.Errorf("unsupported XML-RPC parameter type: %T", param)
+		}
+		b.WriteString(`</value></param>`)
+	}
+	b.WriteString(`</params></methodCall>`)
+	return b.Bytes(), nil
+}
+
+// decodeResponse decodes the response of a call, structs are decoded
+// as maps, arrays as slices and scalars as their Go types
+func decodeResponse(d []byte) (interface{}, error) {
+	var response xmlrpcResponse
+	err := xml.Unmarshal(d, &response)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode XML-RPC response: %v", err)
+	}
+	if response.Fault != nil {
+		fault, _ := response.Fault.decode().(map[string]interface{})
+		code, _ := fault["faultCode"].(int)
+		message, _ := fault["faultString"].(string)
+		return nil, &Fault{Code: code, String: message}
+	}
+	if len(response.Params) == 0 {
+		return nil, nil
+	}
+	return response.Params[0].decode(), nil
+}
+
+func (v *xmlrpcValue) decode() interface{} {
+	switch {
+	case v.String != nil:
+		return *v.String
+	case v.Int != nil:
+		n, _ := strconv.Atoi(strings.TrimSpace(*v.Int))
+		return n
+	case v.I4 != nil:
+		n, _ := strconv.Atoi(strings.TrimSpace(*v.I4))
+		return n
+	case v.Boolean != nil:
+		return strings.TrimSpace(*v.Boolean) == "1"
+	case v.Double != nil:
+		f, _ := strconv.ParseFloat(strings.TrimSpace(*v.Double), 64)
+		return f
+	case v.Struct != nil:
+		m := make(map[string]interface{})
+		for _, member := range v.Struct.Members {
+			m[member.Name] = member.Value.decode()
+		}
+		return m
+	case v.Array != nil:
+		var values []interface{}
+		for _, value := range v.Array.Values {
+			values = append(values, value.decode())
+		}
+		return values
+	}
+	// Values without type are strings
+	return v.Text
+}
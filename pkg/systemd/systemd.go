@@ -21,10 +21,14 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/coreos/go-systemd/daemon"
 	"github.com/coreos/go-systemd/dbus"
 	"github.com/coreos/go-systemd/util"
+	godbus "github.com/godbus/dbus"
 )
 
 type SystemD interface {
@@ -33,7 +37,15 @@ type SystemD interface {
 	Close()
 
 	NotifyReady() error
+	NotifyStatus(string) error
+	NotifyWatchdog() error
+	NotifyReloading() error
+	NotifyStopping() error
+	WatchdogInterval() time.Duration
+
 	Reload(context.Context, string) error
+	ServiceAction(context.Context, string, string, syscall.Signal) error
+	WaitActive(context.Context, string) error
 }
 
 type SystemdConfigurer interface {
@@ -85,18 +97,108 @@ func (s *systemd) CanNotify() bool {
 	return true
 }
 
-func (s *systemd) NotifyReady() error {
-	sent, err := daemon.SdNotify(false, "READY=1")
+func (s *systemd) notify(state, description string) error {
+	sent, err := daemon.SdNotify(false, state)
 	if err != nil {
-		return fmt.Errorf("couldn't notify ready: %v", err)
+		return fmt.Errorf("couldn't notify %s: %v", description, err)
 	}
 	if !sent {
-		return fmt.Errorf("ready notification to systemd was not sent")
+		return fmt.Errorf("%s notification to systemd was not sent", description)
 	}
 	return nil
 }
 
+func (s *systemd) NotifyReady() error {
+	return s.notify("READY=1", "ready")
+}
+
+// NotifyStatus sends a free-form status, shown by systemctl status
+func (s *systemd) NotifyStatus(status string) error {
+	status = strings.Replace(status, "\n", " ", -1)
+	return s.notify("STATUS="+status, "status")
+}
+
+func (s *systemd) NotifyWatchdog() error {
+	return s.notify("WATCHDOG=1", "watchdog")
+}
+
+func (s *systemd) NotifyReloading() error {
+	return s.notify("RELOADING=1", "reloading")
+}
+
+func (s *systemd) NotifyStopping() error {
+	return s.notify("STOPPING=1", "stopping")
+}
+
+// WatchdogInterval returns the interval of the watchdog configured for
+// the service, or zero if disabled
+func (s *systemd) WatchdogInterval() time.Duration {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Printf("Couldn't check watchdog: %v", err)
+		return 0
+	}
+	return interval
+}
+
+// Actions that can be run on units
+const (
+	ActionReload             = "reload"
+	ActionRestart            = "restart"
+	ActionTryRestart         = "try-restart"
+	ActionReloadOrRestart    = "reload-or-restart"
+	ActionReloadOrTryRestart = "reload-or-try-restart"
+	ActionStart              = "start"
+	ActionKill               = "kill"
+)
+
+// Actions supported on units
+var Actions = []string{
+	ActionReload,
+	ActionRestart,
+	ActionTryRestart,
+	ActionReloadOrRestart,
+	ActionReloadOrTryRestart,
+	ActionStart,
+	ActionKill,
+}
+
+const (
+	unitJobMode             = "replace"
+	unitActiveStateProperty = "ActiveState"
+	unitActiveState         = "active"
+	unitFailedState         = "failed"
+	activeStatePollInterval = 500 * time.Millisecond
+)
+
+type unitJob func(c *dbus.Conn, name, mode string, ch chan<- string) (int, error)
+
+var unitJobs = map[string]unitJob{
+	ActionReload:             (*dbus.Conn).ReloadUnit,
+	ActionRestart:            (*dbus.Conn).RestartUnit,
+	ActionTryRestart:         (*dbus.Conn).TryRestartUnit,
+	ActionReloadOrRestart:    (*dbus.Conn).ReloadOrRestartUnit,
+	ActionReloadOrTryRestart: (*dbus.Conn).ReloadOrTryRestartUnit,
+	ActionStart:              (*dbus.Conn).StartUnit,
+}
+
 func (s *systemd) Reload(ctx context.Context, name string) error {
+	return s.ServiceAction(ctx, name, ActionReloadOrRestart, 0)
+}
+
+// ServiceAction runs an action on a unit and waits for its job to finish,
+// signal is only used by the kill action
+func (s *systemd) ServiceAction(ctx context.Context, name, action string, signal syscall.Signal) error {
+	job, found := unitJobs[action]
+	if action != ActionKill && !found {
+		return fmt.Errorf("unknown action for systemd units: %s", action)
+	}
+
+	if action == ActionKill {
+		// Killing units doesn't create jobs
+		return killUnit(name, signal)
+	}
+
 	c, err := dbus.New()
 	if err != nil {
 		return err
@@ -104,7 +206,7 @@ func (s *systemd) Reload(ctx context.Context, name string) error {
 	defer c.Close()
 
 	result := make(chan string, 1)
-	_, err = c.ReloadOrRestartUnit(name, "replace", result)
+	_, err = job(c, name, unitJobMode, result)
 	if err != nil {
 		return err
 	}
@@ -113,8 +215,53 @@ func (s *systemd) Reload(ctx context.Context, name string) error {
 		return ctx.Err()
 	case r := <-result:
 		if r != "done" {
-			return fmt.Errorf("reload job for %s is not done (found: %s)", name, r)
+			return fmt.Errorf("%s job for %s is not done (found: %s)", action, name, r)
 		}
 	}
 	return nil
 }
+
+// killUnit sends a signal to all the processes of a unit. It is called
+// directly through D-Bus because go-systemd ignores errors of this call, as
+// the ones of units that are not loaded.
+func killUnit(name string, signal syscall.Signal) error {
+	conn, err := godbus.SystemBus()
+	if err != nil {
+		return err
+	}
+	manager := conn.Object("org.freedesktop.systemd1", "/org/freedesktop/systemd1")
+	call := manager.Call("org.freedesktop.systemd1.Manager.KillUnit", 0, name, "all", int32(signal))
+	if call.Err != nil {
+		return fmt.Errorf("couldn't kill unit %s: %v", name, call.Err)
+	}
+	return nil
+}
+
+// WaitActive waits till the unit is active, it fails if the unit fails
+// or the context is done before
+func (s *systemd) WaitActive(ctx context.Context, name string) error {
+	c, err := dbus.New()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for {
+		p, err := c.GetUnitProperty(name, unitActiveStateProperty)
+		if err != nil {
+			return err
+		}
+		state, _ := p.Value.Value().(string)
+		switch state {
+		case unitActiveState:
+			return nil
+		case unitFailedState:
+			return fmt.Errorf("unit %s failed", name)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("unit %s is not active (found: %s): %v", name, state, ctx.Err())
+		case <-time.After(activeStatePollInterval):
+		}
+	}
+}
@@ -17,12 +17,16 @@ limitations under the License.
 package vault
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
+	"github.com/tuenti/pouch/pkg/audit"
+	"github.com/tuenti/pouch/pkg/logging"
+	"github.com/tuenti/pouch/pkg/metrics"
+
 	"github.com/hashicorp/vault/api"
 )
 
@@ -43,6 +47,10 @@ const (
 	AuthAppRoleURL  = "/v1/sys/auth/approle"
 	AppRoleLoginURL = "/v1/auth/approle/login"
 	AppRoleURL      = "/v1/auth/approle/role"
+
+	metricTokenTTL             = "pouch_vault_token_ttl_seconds"
+	metricTokenExpiry          = "pouch_vault_token_expiry_timestamp_seconds"
+	metricTokenRenewalFailures = "pouch_vault_token_renewal_failures_total"
 )
 
 type RequestOptions struct {
@@ -52,10 +60,11 @@ type RequestOptions struct {
 }
 
 type Vault interface {
-	Login() error
+	Login(context.Context) error
 	Request(method, urlPath string, options *RequestOptions) (*api.Secret, *api.Response, error)
 	UnwrapSecretID(token string) error
 	GetToken() string
+	GetSecretID() string
 }
 
 type Config struct {
@@ -63,6 +72,9 @@ type Config struct {
 	RoleID   string `json:"role_id,omitempty"`
 	SecretID string `json:"secret_id,omitempty"`
 	Token    string `json:"token,omitempty"`
+
+	// Auditor of requests, login and unwrap of secret IDs, optional
+	Auditor audit.Auditor `json:"-"`
 }
 
 type vaultApi struct {
@@ -70,6 +82,8 @@ type vaultApi struct {
 	RoleID   string
 	SecretID string
 	Token    string
+
+	auditor audit.Auditor
 }
 
 func New(c Config) Vault {
@@ -78,6 +92,14 @@ func New(c Config) Vault {
 		RoleID:   c.RoleID,
 		SecretID: c.SecretID,
 		Token:    c.Token,
+
+		auditor: c.Auditor,
+	}
+}
+
+func (v *vaultApi) audit(e audit.Event) {
+	if v.auditor != nil {
+		v.auditor.Audit(e)
 	}
 }
 
@@ -126,7 +148,9 @@ func (v *vaultApi) renewToken() (renewable bool, err error) {
 	return true, err
 }
 
-func (v *vaultApi) autoRenewToken() {
+// autoRenewToken renews the token when needed, till it cannot be renewed
+// anymore or the context is done
+func (v *vaultApi) autoRenewToken(ctx context.Context) {
 	const (
 		stateUpdateTTL = iota
 		stateRenew
@@ -143,54 +167,60 @@ func (v *vaultApi) autoRenewToken() {
 			// For any other errors we should continue retryining till we
 			// confirm that the token is definitively invalid
 			if invalid {
-				log.Println("Invalid token")
+				logging.Errorf("Invalid token")
 				return
 			}
 
 			if err != nil {
-				log.Printf("Couldn't obtain token TTL: %s\n", err)
+				logging.Warnf("Couldn't obtain token TTL: %s", err)
 				next = TokenRetryPeriod
 				break
 			}
 
+			metrics.SetGauge(metricTokenTTL, nil, float64(ttl))
 			if ttl == 0 {
-				log.Println("Using token without expiration")
+				logging.Infof("Using token without expiration")
+				metrics.Delete(metricTokenExpiry, nil)
 				return
 			}
+			metrics.SetTimestamp(metricTokenExpiry, nil, time.Now().Add(time.Duration(ttl)*time.Second))
 
 			state = stateRenew
 			next = time.Duration(float64(ttl)*AutoRenewPeriodRatio) * time.Second
-			log.Printf("Next token renewal in %s", next)
+			logging.Infof("Next token renewal in %s", next)
 
 		case stateRenew:
-			log.Println("Renewing token")
+			logging.Infof("Renewing token")
 			renewable, err := v.renewToken()
 
 			if err != nil {
-				log.Printf("Couldn't renew token: %s\n", err)
+				logging.Warnf("Couldn't renew token: %s", err)
+				metrics.IncrCounter(metricTokenRenewalFailures, nil, 1)
 				next = TokenRetryPeriod
 			} else {
+				metrics.IncrCounter(metricTokenRenewalFailures, nil, 0)
 				state = stateUpdateTTL
 				next = 0
 			}
 
 			if !renewable {
-				log.Println("Token cannot be renewed anymore")
+				logging.Warnf("Token cannot be renewed anymore")
 				return
 			}
 		}
 
 		select {
 		case <-time.After(next):
+		case <-ctx.Done():
+			logging.Infof("Stopping token autorenewal")
+			return
 		}
 	}
-
-	log.Println("Won't autorenew token")
 }
 
-func (v *vaultApi) Login() error {
+func (v *vaultApi) Login(ctx context.Context) error {
 	if v.Token != "" {
-		go v.autoRenewToken()
+		go v.autoRenewToken(ctx)
 		return nil
 	}
 	if v.RoleID == "" {
@@ -202,18 +232,35 @@ func (v *vaultApi) Login() error {
 		data["secret_id"] = v.SecretID
 	}
 	options := RequestOptions{Data: data}
-	s, _, err := v.Request(http.MethodPost, AppRoleLoginURL, &options)
+	s, resp, err := v.Request(http.MethodPost, AppRoleLoginURL, &options)
+	if err == nil && (s == nil || s.Auth == nil) {
+		err = fmt.Errorf("no token found in login response")
+	}
+	event := audit.Event{Type: audit.LoginEvent, Path: AppRoleLoginURL}
+	if resp != nil {
+		event.Status = resp.StatusCode
+	}
+	event.SetError(err)
+	v.audit(event)
 	if err != nil {
 		return err
 	}
 
 	v.Token = s.Auth.ClientToken
-	go v.autoRenewToken()
+	go v.autoRenewToken(ctx)
 
 	return nil
 }
 
 func (v *vaultApi) UnwrapSecretID(token string) error {
+	err := v.unwrapSecretID(token)
+	event := audit.Event{Type: audit.UnwrapEvent}
+	event.SetError(err)
+	v.audit(event)
+	return err
+}
+
+func (v *vaultApi) unwrapSecretID(token string) error {
 	c, err := v.getClient()
 	if err != nil {
 		return err
@@ -261,9 +308,22 @@ func (v *vaultApi) Request(method, urlPath string, options *RequestOptions) (*ap
 	}
 
 	resp, err := c.RawRequest(r)
+	logger := logging.WithFields(logging.Fields{logging.PathField: urlPath, "method": method})
+	event := audit.Event{Type: audit.VaultRequestEvent, Method: method, Path: urlPath}
+	if resp != nil {
+		logger = logger.WithField("status", resp.StatusCode)
+		event.Status = resp.StatusCode
+	}
+	event.SetError(err)
+	if urlPath != AppRoleLoginURL {
+		// Logins are audited as login events
+		v.audit(event)
+	}
 	if err != nil {
+		logger.WithField(logging.ErrorField, err).Debugf("Vault request failed")
 		return nil, resp, err
 	}
+	logger.Debugf("Vault request")
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNoContent {
@@ -276,3 +336,7 @@ func (v *vaultApi) Request(method, urlPath string, options *RequestOptions) (*ap
 func (v *vaultApi) GetToken() string {
 	return v.Token
 }
+
+func (v *vaultApi) GetSecretID() string {
+	return v.SecretID
+}
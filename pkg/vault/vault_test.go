@@ -17,6 +17,7 @@ limitations under the License.
 package vault
 
 import (
+	"context"
 	"fmt"
 	nethttp "net/http"
 	"net/http/httptest"
@@ -92,7 +93,7 @@ func TestLogin(t *testing.T) {
 		SecretID: secretID,
 	}
 
-	err = v.Login()
+	err = v.Login(context.Background())
 	if err != nil {
 		t.Fatalf("couldn't login: %v", err)
 	}
@@ -134,7 +135,7 @@ func TestLoginWithWrappedSecret(t *testing.T) {
 		t.Fatalf("couldn't unwrap secret-id: %v", err)
 	}
 
-	err = v.Login()
+	err = v.Login(context.Background())
 	if err != nil {
 		t.Fatalf("couldn't login: %v", err)
 	}
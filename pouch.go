@@ -21,35 +21,76 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
+	"github.com/tuenti/pouch/pkg/audit"
+	"github.com/tuenti/pouch/pkg/logging"
+	"github.com/tuenti/pouch/pkg/metrics"
 	"github.com/tuenti/pouch/pkg/vault"
 )
 
 const (
 	DefaultFileMode   = os.FileMode(0600)
 	SecretRetryPeriod = 5 * time.Second
+
+	// Time to wait to retry secrets whose requests were rejected, as they
+	// probably need some intervention, like fixing their policies
+	SecretRejectedRetryPeriod = time.Minute
+
+	// Time to wait for notifiers when stopping
+	DefaultShutdownTimeout = 30 * time.Second
 )
 
 type Pouch interface {
 	Run(context.Context) error
-	Watch(path string) error
+	Watch(ctx context.Context, path string) error
 	AddStatusNotifier(StatusNotifier)
+	NotifyReloading()
+	NotifyStopping()
 	ServiceReloader(Reloader)
+	StrictTemplates(bool)
+	ShutdownTimeout(time.Duration)
+	Auditor(audit.Auditor)
+	AuditDigestKey([]byte)
+	OnExpiryWarning(string)
+	Status() Status
+	ControlHandler() http.Handler
 }
 
 type StatusNotifier interface {
 	NotifyReady() error
+	NotifyStatus(string) error
+	NotifyWatchdog() error
+	NotifyReloading() error
+	NotifyStopping() error
+
+	// Interval of the watchdog, zero if it is not enabled
+	WatchdogInterval() time.Duration
 }
 
 type Reloader interface {
 	Reload(context.Context, string) error
 }
 
+// Action on services that sends a signal, other actions depend on the
+// service manager
+const ServiceActionKill = "kill"
+
+// ServiceController is a reloader that can run other actions on services
+type ServiceController interface {
+	Reloader
+
+	ServiceAction(ctx context.Context, service, action string, signal syscall.Signal) error
+	WaitActive(ctx context.Context, service string) error
+}
+
 type pouch struct {
 	State *PouchState
 
@@ -59,36 +100,32 @@ type pouch struct {
 	Notifiers map[string]NotifierConfig
 	Reloader  Reloader
 
+	auditor        audit.Auditor
+	auditDigestKey []byte
+
 	statusNotifiers  []StatusNotifier
-	pendingNotifiers map[string]bool
+	pendingNotifiers map[string]*Notification
+	strictTemplates  bool
+	onExpiryWarning  string
+	ready            bool
+	lastStatus       string
+
+	// Requests of the control API, handled from the main loop
+	controlRequests chan *controlRequest
+
+	// Status published for other goroutines
+	status     Status
+	statusLock sync.Mutex
+
+	// Context for notifiers, done some time after pouch is stopped
+	notifyCtx       context.Context
+	shutdownTimeout time.Duration
 }
 
-func getFileContent(fc FileConfig, data interface{}, secretFunc interface{}) (string, error) {
-	if fc.Template != "" && fc.TemplateFile != "" {
-		return "", fmt.Errorf("inline template and template file specified")
-	}
-	var t *template.Template
-	funcMap := template.FuncMap{
-		"secret": secretFunc,
-	}
-	var err error
-	switch {
-	case fc.Template != "":
-		t, err = template.New("inline-template").Funcs(funcMap).Parse(fc.Template)
-		if err != nil {
-			return "", err
-		}
-	case fc.TemplateFile != "":
-		d, err := ioutil.ReadFile(fc.TemplateFile)
-		if err != nil {
-			return "", err
-		}
-		t, err = template.New(fc.TemplateFile).Funcs(funcMap).Parse(string(d))
-		if err != nil {
-			return "", err
-		}
-	default:
-		return "", fmt.Errorf("no content defined for file %s", fc.Path)
+func getFileContent(fc FileConfig, data interface{}, funcMap template.FuncMap) (string, error) {
+	t, err := parseFileTemplate(fc, funcMap)
+	if err != nil {
+		return "", err
 	}
 	var b bytes.Buffer
 	err = t.Execute(&b, data)
@@ -112,39 +149,87 @@ func dirMode(mode os.FileMode) os.FileMode {
 var dataFuncMap = template.FuncMap{
 	"env":      os.Getenv,
 	"hostname": os.Hostname,
+	"fqdn":     fqdn,
+	"ip":       primaryIP,
+	"ips":      allIPs,
+	"file":     fileContent,
+	"join":     join,
+}
+
+func resolveTemplate(name, text string, funcMaps ...template.FuncMap) (string, error) {
+	t := template.New(name).Funcs(dataFuncMap)
+	for _, funcMap := range funcMaps {
+		t = t.Funcs(funcMap)
+	}
+	t, err := t.Parse(text)
+	if err != nil {
+		return text, err
+	}
+	var b bytes.Buffer
+	err = t.Execute(&b, nil)
+	if err != nil {
+		return text, err
+	}
+	return b.String(), nil
 }
 
-func resolveData(data map[string]interface{}) map[string]interface{} {
+func resolveData(data map[string]interface{}, funcMaps ...template.FuncMap) map[string]interface{} {
 	result := make(map[string]interface{})
 	for k, d := range data {
-		resolved, err := func() (interface{}, error) {
-			v, ok := d.(string)
-			if !ok {
-				return d, nil
-			}
-			t, err := template.New("secret-data").Funcs(dataFuncMap).Parse(v)
-			if err != nil {
-				return d, err
-			}
-			var b bytes.Buffer
-			err = t.Execute(&b, nil)
-			if err != nil {
-				return d, err
-			}
-			return b.String(), nil
-		}()
+		v, ok := d.(string)
+		if !ok {
+			result[k] = d
+			continue
+		}
+		resolved, err := resolveTemplate("secret-data", v, funcMaps...)
 		if err != nil {
-			log.Printf("When resolving data template '%s' for '%s': %v", d, k, err)
+			logging.Warnf("When resolving data template '%s' for '%s': %v", d, k, err)
 		}
 		result[k] = resolved
 	}
 	return result
 }
 
+// secretFuncMap returns the functions available in the templates of secret
+// configurations, they can use values of other secrets
+func (p *pouch) secretFuncMap() template.FuncMap {
+	secretFunc := func(name, key string) (interface{}, error) {
+		secret, found := p.State.Secrets[name]
+		if !found {
+			return nil, fmt.Errorf("secret not available: %s", name)
+		}
+		value, found := secret.Data[key]
+		if !found {
+			return nil, fmt.Errorf("unkown key in secret '%s': %s", name, key)
+		}
+		return value, nil
+	}
+	return template.FuncMap{"secret": secretFunc}
+}
+
 func (p *pouch) resolveSecret(name string, c SecretConfig) (retry bool, err error) {
-	options := &vault.RequestOptions{Data: resolveData(c.Data)}
-	s, resp, err := p.Vault.Request(c.HTTPMethod, c.VaultURL, options)
+	funcMap := p.secretFuncMap()
+	url, err := resolveTemplate("vault-url", c.VaultURL, funcMap)
+	if err != nil {
+		return false, fmt.Errorf("couldn't resolve vault url for '%s': %v", name, err)
+	}
+	method, err := resolveTemplate("http-method", c.HTTPMethod, funcMap)
+	if err != nil {
+		return false, fmt.Errorf("couldn't resolve http method for '%s': %v", name, err)
+	}
+	options := &vault.RequestOptions{Data: resolveData(c.Data, funcMap)}
+	s, resp, err := p.Vault.Request(method, url, options)
+	event := audit.Event{Type: audit.SecretEvent, Secret: name, Method: method, Path: url}
+	if resp != nil {
+		event.Status = resp.StatusCode
+	}
+	if s != nil {
+		event.LeaseDuration = s.LeaseDuration
+	}
+	event.SetError(err)
+	p.audit(event)
 	if err != nil {
+		metrics.IncrCounter(metricSecretFetchErrors, metrics.Labels{"secret": name}, 1)
 		switch {
 		case resp == nil:
 			// Retry if there was a connection error and no response
@@ -161,24 +246,60 @@ func (p *pouch) resolveSecret(name string, c SecretConfig) (retry bool, err erro
 		}
 	}
 	p.State.SetSecret(name, s)
+	p.redactSecrets()
+	metrics.IncrCounter(metricSecretFetchErrors, metrics.Labels{"secret": name}, 0)
+	updateSecretMetrics(p.State.Secrets[name])
 	err = p.State.Save()
 	if err != nil {
-		log.Printf("Couldn't save state: %s", err)
+		logging.Errorf("Couldn't save state: %s", err)
 	}
 	return false, nil
 }
 
-func (p *pouch) resolveFile(fc FileConfig) error {
-	mode := os.FileMode(fc.Mode)
-	if mode == 0 {
-		mode = DefaultFileMode
+// redactSecrets makes logs redact the values of current secrets, the
+// token and the secret ID
+func (p *pouch) redactSecrets() {
+	values := []string{p.State.Token}
+	if p.Vault != nil {
+		values = append(values, p.Vault.GetSecretID())
 	}
-	dir := path.Dir(fc.Path)
-	err := os.MkdirAll(dir, dirMode(mode))
-	if err != nil {
-		return err
+	for _, s := range p.State.Secrets {
+		for _, v := range s.Data {
+			values = secretValues(values, v)
+		}
+	}
+	logging.SetSecrets(values)
+}
+
+// secretValues appends the string values found in the data of a secret,
+// including the ones in nested maps and lists
+func secretValues(values []string, data interface{}) []string {
+	switch data := data.(type) {
+	case string:
+		values = append(values, data)
+	case map[string]interface{}:
+		for _, v := range data {
+			values = secretValues(values, v)
+		}
+	case []interface{}:
+		for _, v := range data {
+			values = secretValues(values, v)
+		}
 	}
+	return values
+}
+
+// fileContent generates the content of a file, from its template or from
+// its secret in the configured format
+func (p *pouch) fileContent(fc FileConfig) (string, error) {
+	if fc.Format != "" {
+		return p.formatFileContent(fc)
+	}
+	return getFileContent(fc, nil, p.fileFuncMap(fc))
+}
 
+// fileFuncMap returns the functions available in templates of files
+func (p *pouch) fileFuncMap(fc FileConfig) template.FuncMap {
 	secretFunc := func(name, key string) (interface{}, error) {
 		secret, found := p.State.Secrets[name]
 		if !found {
@@ -192,63 +313,183 @@ func (p *pouch) resolveFile(fc FileConfig) error {
 		return value, nil
 	}
 
-	content, err := getFileContent(fc, nil, secretFunc)
-	if err != nil {
-		return err
+	return template.FuncMap{
+		"secret": secretFunc,
+		"vault":  p.vaultFunc(fc),
 	}
+}
 
-	file, err := os.OpenFile(fc.Path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, mode)
+// writeFileAtomic writes a file to a temporary file in the same directory
+// that replaces the file once completely written, so the file is never
+// partially written, even if pouch is interrupted. If the file is a
+// symlink, the file it points to is replaced. The owner and group of the
+// replaced file are kept.
+func writeFileAtomic(path string, content []byte, mode os.FileMode) (int, error) {
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".pouch-")
 	if err != nil {
-		return fmt.Errorf("couldn't open %s file to be written: %s", fc.Path, err)
+		return 0, fmt.Errorf("couldn't open %s file to be written: %s", path, err)
 	}
+	tmpPath := file.Name()
+	defer os.Remove(tmpPath)
 	defer file.Close()
 
-	bytesWritten, err := file.Write([]byte(content))
+	err = file.Chmod(mode)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't set mode of '%s': %s", path, err)
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			err = file.Chown(int(stat.Uid), int(stat.Gid))
+			if err != nil {
+				logging.WithField(logging.FileField, path).Warnf("Couldn't keep owner and group of file: %v", err)
+			}
+		}
+	}
+
+	bytesWritten, err := file.Write(content)
 	if err != nil {
-		return fmt.Errorf("couldn't write secret in '%s': %s", fc.Path, err)
+		return 0, fmt.Errorf("couldn't write secret in '%s': %s", path, err)
 	}
 
 	// Ensure file contents have been committed to disk
 	err = file.Sync()
 	if err != nil {
-		return fmt.Errorf("not able to commit the file '%s' to disk: %s", fc.Path, err)
+		return 0, fmt.Errorf("not able to commit the file '%s' to disk: %s", path, err)
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't replace '%s': %s", path, err)
+	}
+	return bytesWritten, nil
+}
+
+func (p *pouch) resolveFile(fc FileConfig) error {
+	if fc.Directory != "" {
+		return p.resolveDirectory(fc)
+	}
+
+	mode := os.FileMode(fc.Mode)
+	if mode == 0 {
+		mode = DefaultFileMode
+	}
+	dir := path.Dir(fc.Path)
+	err := os.MkdirAll(dir, dirMode(mode))
+	if err != nil {
+		return err
+	}
+
+	content, err := p.fileContent(fc)
+	if err != nil {
+		return err
+	}
+	content, err = decodeContent(fc.Decode, content)
+	if err != nil {
+		return fmt.Errorf("couldn't generate content for '%s': %v", fc.Path, err)
+	}
+
+	bytesWritten, err := writeFileAtomic(fc.Path, []byte(content), mode)
+	p.auditFile(fc.Path, []byte(content), mode, err)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Written %d bytes into %s", bytesWritten, fc.Path)
+	logging.WithField(logging.FileField, fc.Path).Infof("Written %d bytes", bytesWritten)
 
-	p.addForNotify(fc.Notify...)
+	p.fileWritten(fc)
 	return nil
 }
 
 func (p *pouch) Run(ctx context.Context) error {
-	err := p.Vault.Login()
+	// Secrets may have been loaded from the state
+	p.redactSecrets()
+
+	err := p.checkTemplates()
+	if err != nil {
+		return err
+	}
+
+	err = p.checkNotifiers()
+	if err != nil {
+		return err
+	}
+
+	// Results of notifiers not configured anymore would be reported as
+	// failing forever
+	for name := range p.State.Notifiers {
+		if _, found := p.Notifiers[name]; !found {
+			p.State.DeleteNotifier(name)
+		}
+	}
+
+	p.NotifyStatus("Logging in to Vault")
+	err = p.Vault.Login(ctx)
 	if err != nil {
 		return err
 	}
+	p.NotifyStatus("Provisioning %d secrets and %d files", len(p.Secrets), len(p.Files))
 	p.State.Token = p.Vault.GetToken()
+	p.redactSecrets()
 	err = p.State.Save()
 	if err != nil {
-		log.Printf("Couldn't save state: %s", err)
+		logging.Errorf("Couldn't save state: %s", err)
+	}
+
+	order, err := p.secretsOrder()
+	if err != nil {
+		return err
 	}
 
-	for name, c := range p.Secrets {
-		if s, found := p.State.Secrets[name]; found {
+	// Secrets are resolved after the secrets they depend on, and they
+	// are requested again if any of these dependencies has changed
+	updated := make(map[string]bool)
+	dependencies, _ := p.secretDependencies()
+	for _, name := range order {
+		s, found := p.State.Secrets[name]
+		if found {
 			// Clean files using this secret, we'll process templates in case
 			// someone has changed
 			s.FilesUsing = nil
-		} else {
-			_, err = p.resolveSecret(name, c)
+		}
+		for _, dep := range dependencies[name] {
+			if updated[dep] {
+				found = false
+			}
+		}
+		if !found {
+			_, err = p.resolveSecret(name, p.Secrets[name])
 			if err != nil {
 				return err
 			}
+			updated[name] = true
 		}
 	}
 
-	for name := range p.State.Secrets {
+	for name, s := range p.State.Secrets {
 		if _, found := p.Secrets[name]; !found {
+			if s.Config != nil {
+				// Secret read from a template, keep it while it is used
+				p.Secrets[name] = *s.Config
+				s.FilesUsing = nil
+				continue
+			}
 			p.State.DeleteSecret(name)
+			deleteSecretMetrics(name)
 		}
 	}
+	for _, s := range p.State.Secrets {
+		updateSecretMetrics(s)
+	}
+
+	err = p.checkSecretKeys()
+	if err != nil {
+		return err
+	}
 
 	for _, fc := range p.Files {
 		err := p.resolveFile(fc)
@@ -256,15 +497,46 @@ func (p *pouch) Run(ctx context.Context) error {
 			return err
 		}
 	}
+	p.pruneDynamicSecrets()
 
 	p.NotifyReady()
 
+	notifyCtx, cancelNotify := shutdownContext(ctx, p.shutdownTimeout)
+	defer cancelNotify()
+	p.notifyCtx = notifyCtx
+
+	var watchdog <-chan time.Time
+	watchdogInterval := p.watchdogInterval()
+	if watchdogInterval > 0 {
+		ticker := time.NewTicker(watchdogInterval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+		p.checkNotifiersDuration(watchdogInterval)
+	}
+
+	var lastIteration time.Time
 	for {
-		p.notifyPending()
+		// The watchdog is only notified here, after the loop completes an
+		// iteration. Debounces and retries of secrets are waited for in
+		// the select below, so the watchdog stops being notified only if
+		// the loop gets stuck, and then the service manager restarts pouch.
+		if watchdogInterval > 0 && !lastIteration.IsZero() {
+			if blocked := time.Since(lastIteration); blocked > watchdogInterval {
+				logging.Warnf("Main loop was blocked for %s, more than the watchdog interval", blocked.Round(time.Second))
+			}
+		}
+		p.NotifyWatchdog()
+		lastIteration = time.Now()
+
+		var wakeup <-chan time.Time
+		if next := p.notifyPending(time.Now()); !next.IsZero() {
+			// Some notifier is waiting for its debounce or minimum interval
+			wakeup = time.After(time.Until(next))
+		}
 
 		err = p.State.Save()
 		if err != nil {
-			log.Printf("Couldn't save state: %s", err)
+			logging.Errorf("Couldn't save state: %s", err)
 		}
 
 		var nextUpdate <-chan time.Time
@@ -272,73 +544,267 @@ func (p *pouch) Run(ctx context.Context) error {
 		if s != nil {
 			nextUpdate = time.After(time.Until(ttu))
 		} else {
-			log.Printf("No secret to update")
+			logging.Infof("No secret to update")
 		}
+		p.updateStatus()
+		p.NotifyStatus("%s", p.statusSummary(s, ttu))
 
 		select {
+		case <-watchdog:
+		case <-wakeup:
+		case r := <-p.controlRequests:
+			r.handle(ctx)
 		case <-nextUpdate:
-			log.Printf("Updating secret '%s'", s.Name)
-			for retry := true; retry; {
-				retry, err = p.resolveSecret(s.Name, p.Secrets[s.Name])
-				if err != nil {
-					if retry {
-						log.Println(err)
-						<-time.After(SecretRetryPeriod)
-					} else {
-						return err
-					}
-				}
-			}
-			for _, f := range p.State.Secrets[s.Name].FilesUsing {
-				log.Printf("Updating file '%s'", f.Path)
-				err = p.resolveFile(p.Files[f.Path])
-				if err != nil {
-					return err
-				}
+			// Pouch keeps running on errors, failed updates are retried
+			// and warned about while their secrets are close to expire
+			err = p.updateSecret(ctx, s.Name)
+			if _, retrying := err.(*secretRetryError); err != nil && !retrying && ctx.Err() == nil {
+				logging.WithFields(logging.Fields{
+					logging.SecretField: s.Name,
+					logging.ErrorField:  err,
+				}).Errorf("Couldn't update secret")
 			}
 		case <-ctx.Done():
+		}
+
+		if ctx.Err() != nil {
+			p.shutdown()
 			return nil
 		}
 	}
 }
 
+// shutdown runs pending notifiers, without waiting for their debounce or
+// interval, and saves the state. Files are always written atomically, and
+// if some secret update was interrupted, its files are generated again on
+// next start.
+func (p *pouch) shutdown() {
+	logging.Infof("Shutting down")
+	p.notifyAllPending()
+
+	err := p.State.Save()
+	if err != nil {
+		logging.Errorf("Couldn't save state: %s", err)
+	}
+}
+
+// secretRetryError is returned when a secret couldn't be updated, and its
+// update has been scheduled to be retried
+type secretRetryError struct {
+	secret string
+	period time.Duration
+	err    error
+}
+
+func (e *secretRetryError) Error() string {
+	return fmt.Sprintf("couldn't update secret '%s', retrying in %s: %v", e.secret, e.period, e.err)
+}
+
+// updateSecret requests again a secret and the secrets depending on it, and
+// updates the files using any of them. If a secret cannot be updated, its
+// update is retried later from the main loop, so it is not blocked while
+// Vault is unavailable, and the secrets depending on it are not updated
+// till then. Secrets whose requests are rejected are also retried, less
+// often, so pouch keeps running and warning about their expiry.
+func (p *pouch) updateSecret(ctx context.Context, name string) error {
+	secrets, err := p.secretDependents(name)
+	if err != nil {
+		return err
+	}
+	dependencies, err := p.secretDependencies()
+	if err != nil {
+		return err
+	}
+
+	var files PriorityFileSortedList
+	var retryErr *secretRetryError
+	failed := make(map[string]bool)
+	for _, name := range secrets {
+		for _, dep := range dependencies[name] {
+			failed[name] = failed[name] || failed[dep]
+		}
+		if failed[name] {
+			continue
+		}
+		logger := logging.WithField(logging.SecretField, name)
+		logger.Infof("Updating secret")
+		retry, err := p.resolveSecret(name, p.Secrets[name])
+		if err != nil {
+			period := SecretRetryPeriod
+			if !retry {
+				period = SecretRejectedRetryPeriod
+			}
+			p.checkExpiry(name, err)
+			logger.WithField(logging.ErrorField, err).Warnf("Couldn't update secret, retrying in %s", period)
+			if s, found := p.State.Secrets[name]; found {
+				s.RetryAfter(period)
+			}
+			failed[name] = true
+			if retryErr == nil {
+				retryErr = &secretRetryError{secret: name, period: period, err: err}
+			}
+			continue
+		}
+		for _, f := range p.State.Secrets[name].FilesUsing {
+			files.Add(f)
+		}
+	}
+
+	for _, f := range files {
+		logging.WithField(logging.FileField, f.Path).Infof("Updating file")
+		err = p.resolveFile(p.Files[f.Path])
+		if err != nil {
+			return err
+		}
+	}
+	if retryErr != nil {
+		return retryErr
+	}
+	return nil
+}
+
 func NewPouch(s *PouchState, vc vault.Vault, sc map[string]SecretConfig, fc []FileConfig, nc map[string]NotifierConfig) Pouch {
+	secretMap := make(map[string]SecretConfig)
+	for name, s := range sc {
+		secretMap[name] = s
+	}
 	fileMap := make(map[string]FileConfig)
 	for _, f := range fc {
-		fileMap[f.Path] = f
+		fileMap[f.Name()] = f
+	}
+	return &pouch{
+		State:     s,
+		Vault:     vc,
+		Secrets:   secretMap,
+		Files:     fileMap,
+		Notifiers: nc,
+
+		controlRequests: make(chan *controlRequest),
+		notifyCtx:       context.Background(),
+		shutdownTimeout: DefaultShutdownTimeout,
 	}
-	return &pouch{State: s, Vault: vc, Secrets: sc, Files: fileMap, Notifiers: nc}
 }
 
 func (p *pouch) ServiceReloader(r Reloader) {
 	p.Reloader = r
 }
 
+func (p *pouch) ShutdownTimeout(timeout time.Duration) {
+	p.shutdownTimeout = timeout
+}
+
+// shutdownContext returns a context that is done after a timeout since
+// parent is done
+func shutdownContext(parent context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+		select {
+		case <-time.After(timeout):
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func (p *pouch) StrictTemplates(strict bool) {
+	p.strictTemplates = strict
+}
+
 func (p *pouch) AddStatusNotifier(n StatusNotifier) {
 	p.statusNotifiers = append(p.statusNotifiers, n)
 }
 
-func (p *pouch) NotifyReady() {
+func (p *pouch) notifyStatusNotifiers(notify func(StatusNotifier) error) {
 	for _, n := range p.statusNotifiers {
-		err := n.NotifyReady()
+		err := notify(n)
 		if err != nil {
-			log.Println(err)
+			logging.Warnf("%v", err)
 		}
 	}
 }
 
-func (p *pouch) addForNotify(names ...string) {
-	if p.pendingNotifiers == nil {
-		p.pendingNotifiers = make(map[string]bool)
+func (p *pouch) NotifyReady() {
+	p.ready = true
+	p.updateStatus()
+	p.notifyStatusNotifiers(StatusNotifier.NotifyReady)
+}
+
+// NotifyStatus notifies a human readable status, if it has changed
+func (p *pouch) NotifyStatus(format string, args ...interface{}) {
+	status := fmt.Sprintf(format, args...)
+	if status == p.lastStatus {
+		return
 	}
-	for _, name := range names {
-		p.pendingNotifiers[name] = true
+	p.lastStatus = status
+	p.notifyStatusNotifiers(func(n StatusNotifier) error {
+		return n.NotifyStatus(status)
+	})
+}
+
+func (p *pouch) NotifyWatchdog() {
+	p.notifyStatusNotifiers(StatusNotifier.NotifyWatchdog)
+}
+
+func (p *pouch) NotifyReloading() {
+	p.notifyStatusNotifiers(StatusNotifier.NotifyReloading)
+}
+
+func (p *pouch) NotifyStopping() {
+	p.notifyStatusNotifiers(StatusNotifier.NotifyStopping)
+}
+
+// watchdogInterval returns the shortest interval of the watchdogs of the
+// status notifiers
+func (p *pouch) watchdogInterval() (interval time.Duration) {
+	for _, n := range p.statusNotifiers {
+		i := n.WatchdogInterval()
+		if i > 0 && (interval == 0 || i < interval) {
+			interval = i
+		}
+	}
+	return
+}
+
+// fileSecrets returns the names of the secrets used by a file
+func (p *pouch) fileSecrets(path string) []string {
+	var secrets []string
+	for name, s := range p.State.Secrets {
+		for _, f := range s.FilesUsing {
+			if f.Path == path {
+				secrets = append(secrets, name)
+			}
+		}
 	}
+	return secrets
 }
 
-func (p *pouch) notifyPending() {
-	for pending := range p.pendingNotifiers {
-		p.Notify(pending)
-		delete(p.pendingNotifiers, pending)
+// fileWritten records that a file has been written, and adds it to its
+// notifiers
+func (p *pouch) fileWritten(fc FileConfig) {
+	p.State.SetFileWritten(fc.Name())
+	metrics.SetTimestamp(metricFileLastWrite, metrics.Labels{"file": fc.Name()}, p.State.Files[fc.Name()].LastWrite)
+	p.addForNotify(fc)
+}
+
+func (p *pouch) addForNotify(fc FileConfig) {
+	if p.pendingNotifiers == nil {
+		p.pendingNotifiers = make(map[string]*Notification)
+	}
+	path := fc.Name()
+	secrets := p.fileSecrets(path)
+	for _, name := range fc.Notify {
+		n, found := p.pendingNotifiers[name]
+		if !found {
+			n = &Notification{Notifier: name}
+			p.pendingNotifiers[name] = n
+		}
+		n.add(path, secrets)
+		n.lastTrigger = time.Now()
 	}
 }
@@ -18,11 +18,19 @@ package pouch
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/tuenti/pouch/pkg/logging"
 	"github.com/tuenti/pouch/pkg/vault"
 
 	"github.com/fsnotify/fsnotify"
@@ -42,9 +50,16 @@ type DummyVault struct {
 	SecretID string
 
 	Responses map[string]*api.Secret
+
+	// Errors returned by requests, with the response received, nil
+	// responses are used for connection errors
+	Errors map[string]*api.Response
+
+	// Number of requests done
+	Requests map[string]int
 }
 
-func (v *DummyVault) Login() error {
+func (v *DummyVault) Login(ctx context.Context) error {
 	if v.Token != "" {
 		return nil
 	}
@@ -72,6 +87,13 @@ func (v *DummyVault) Request(method, urlPath string, options *vault.RequestOptio
 		v.T.Fatalf("incorrect token on request")
 	}
 	k := method + urlPath
+	if v.Requests == nil {
+		v.Requests = make(map[string]int)
+	}
+	v.Requests[k]++
+	if resp, found := v.Errors[k]; found {
+		return nil, resp, errors.New("request failed")
+	}
 	s, ok := v.Responses[k]
 	if !ok {
 		v.T.Fatal("incorrect response")
@@ -83,6 +105,10 @@ func (v *DummyVault) GetToken() string {
 	return v.Token
 }
 
+func (v *DummyVault) GetSecretID() string {
+	return v.SecretID
+}
+
 func newTestState() (state *PouchState, cleanup func()) {
 	f, _ := ioutil.TempFile("", "pouch-state-test")
 	f.Close()
@@ -224,7 +250,7 @@ func TestPouchWatch(t *testing.T) {
 
 	finished := make(chan error)
 	go func() {
-		finished <- pouch.Watch(secretWrapPath.Name())
+		finished <- pouch.Watch(context.Background(), secretWrapPath.Name())
 	}()
 
 	secretWrapPath.Write([]byte("wrap"))
@@ -279,3 +305,303 @@ func TestResolveDataTemplates(t *testing.T) {
 	assert.Equal(t, envValue, resolvedData["env"])
 	assert.Equal(t, hostname, resolvedData["hostname"])
 }
+
+func TestPouchRunDynamicSecrets(t *testing.T) {
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/foo": &api.Secret{
+				Data: map[string]interface{}{"foo": "secretfoo"},
+			},
+			"POST/v1/pki/issue/foo": &api.Secret{
+				Data: map[string]interface{}{"certificate": "cert"},
+			},
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	files := []FileConfig{
+		{Path: path.Join(tmpdir, "foo"), Template: `{{ with vault "/v1/secret/foo" }}{{ .foo }}{{ end }}`},
+		{Path: path.Join(tmpdir, "bar"), Template: `{{ (vault "/v1/secret/foo").foo }} {{ (vault "/v1/pki/issue/foo" "common_name=foo").certificate }}`},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, v, nil, files, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = p.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := ioutil.ReadFile(path.Join(tmpdir, "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "secretfoo", string(d))
+
+	d, err = ioutil.ReadFile(path.Join(tmpdir, "bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "secretfoo cert", string(d))
+
+	name := dynamicSecretName("GET", "/v1/secret/foo", nil)
+	s, found := state.Secrets[name]
+	if assert.True(t, found, "Secret read from template should be in state") {
+		assert.Equal(t, 2, len(s.FilesUsing))
+		assert.Equal(t, "/v1/secret/foo", s.Config.VaultURL)
+	}
+	assert.Equal(t, 2, len(state.Secrets))
+
+	// Data of secrets is not saved in the state, it is taken again from
+	// the templates
+	pkiName := dynamicSecretName("POST", "/v1/pki/issue/foo", []string{"common_name=foo"})
+	saved, err := LoadState(state.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Contains(t, saved.Secrets, pkiName) {
+		assert.Nil(t, saved.Secrets[pkiName].Config.Data)
+	}
+	restarted := NewPouch(saved, v, nil, files, nil).(*pouch)
+	err = restarted.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "foo", restarted.Secrets[pkiName].Data["common_name"])
+}
+
+func TestResolveDataFileTemplates(t *testing.T) {
+	f, err := ioutil.TempFile("", "pouch-machine-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("0123456789abcdef\n")
+	f.Close()
+
+	data := map[string]interface{}{
+		"file":  "{{ file \"" + f.Name() + "\" }}",
+		"join":  "{{ join \",\" (ips) }}",
+		"other": 42,
+	}
+
+	resolvedData := resolveData(data)
+
+	ips, _ := allIPs()
+	assert.Equal(t, "0123456789abcdef", resolvedData["file"])
+	assert.Equal(t, strings.Join(ips, ","), resolvedData["join"])
+	assert.Equal(t, 42, resolvedData["other"])
+}
+
+func TestResolveSecretURLTemplate(t *testing.T) {
+	hostname, _ := os.Hostname()
+	v := &DummyVault{
+		T: t,
+
+		ExpectedToken: "token",
+		Token:         "token",
+
+		Responses: map[string]*api.Secret{
+			"GET/v1/secret/hosts/" + hostname: &api.Secret{
+				Data: map[string]interface{}{"foo": "secretfoo"},
+			},
+		},
+	}
+	secrets := map[string]SecretConfig{
+		"foo": {
+			VaultURL:   "/v1/secret/hosts/{{ hostname }}",
+			HTTPMethod: "{{ \"GET\" }}",
+		},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, v, secrets, nil, nil).(*pouch)
+
+	_, err := p.resolveSecret("foo", secrets["foo"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "secretfoo", state.Secrets["foo"].Data["foo"])
+}
+
+func TestPouchRunShutdown(t *testing.T) {
+	v := &DummyVault{
+		T:             t,
+		Token:         "token",
+		ExpectedToken: "token",
+		Responses: map[string]*api.Secret{
+			"GET/v1/foo": &api.Secret{
+				Data: map[string]interface{}{"foo": "secretfoo"},
+			},
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/foo", HTTPMethod: "GET"},
+	}
+	files := []FileConfig{
+		{Path: path.Join(tmpdir, "foo"), Template: `{{ secret "foo" "foo" }}`, Notify: []string{"reload"}},
+	}
+	notified := path.Join(tmpdir, "notified")
+	notifiers := map[string]NotifierConfig{
+		"reload": {Command: "touch " + notified, Debounce: "1h"},
+	}
+
+	state, cleanup := newTestState()
+	defer cleanup()
+	os.Remove(state.Path)
+	pouch := NewPouch(state, v, secrets, files, notifiers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = pouch.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(notified)
+	assert.NoError(t, err, "Pending notifiers should be run on shutdown")
+
+	saved, err := LoadState(state.Path)
+	if assert.NoError(t, err, "State should be saved on shutdown") {
+		assert.Contains(t, saved.Secrets, "foo")
+		assert.Contains(t, saved.Notifiers, "reload")
+	}
+
+	matches, _ := filepath.Glob(path.Join(tmpdir, ".*.pouch-*"))
+	assert.Empty(t, matches, "No temporary files should be left")
+}
+
+func TestPouchRunSecretRetry(t *testing.T) {
+	v := &DummyVault{
+		T:             t,
+		Token:         "token",
+		ExpectedToken: "token",
+		Errors: map[string]*api.Response{
+			"GET/v1/foo": nil,
+		},
+	}
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/foo", HTTPMethod: "GET"},
+	}
+	files := []FileConfig{
+		{Path: path.Join(tmpdir, "foo"), Template: `{{ secret "foo" "foo" }}`},
+	}
+
+	// Secret needs to be updated, but vault is unavailable
+	state, cleanup := newTestState()
+	defer cleanup()
+	state.SetSecret("foo", &api.Secret{
+		LeaseDuration: 1000,
+		Data:          map[string]interface{}{"foo": "secretfoo"},
+	})
+	state.Secrets["foo"].Timestamp = time.Now().Add(-900 * time.Second)
+	p := NewPouch(state, v, secrets, files, nil)
+	handler := p.ControlHandler()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.Run(ctx) }()
+
+	// Main loop is not blocked while the update is retried
+	time.Sleep(100 * time.Millisecond)
+	reqCtx, cancelReq := context.WithTimeout(context.Background(), time.Second)
+	defer cancelReq()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", ControlSecretsPath, nil).WithContext(reqCtx))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	cancel()
+	assert.NoError(t, <-done)
+	assert.Equal(t, 1, v.Requests["GET/v1/foo"], "Update should be retried after the retry period")
+	assert.Equal(t, "secretfoo", state.Secrets["foo"].Data["foo"])
+}
+
+func TestRedactSecrets(t *testing.T) {
+	state, cleanup := newTestState()
+	defer cleanup()
+	state.Token = "s3cr3ttoken"
+	state.Secrets = map[string]*SecretState{
+		"kv": {Name: "kv", Data: SecretData{
+			"data": map[string]interface{}{
+				"password": "s3cr3tpassword",
+				"keys":     []interface{}{"s3cr3tkey", map[string]interface{}{"old": "s3cr3toldkey"}},
+			},
+		}},
+	}
+	v := &DummyVault{SecretID: "s3cr3tsecretid"}
+	p := NewPouch(state, v, nil, nil, nil).(*pouch)
+	p.redactSecrets()
+	defer logging.SetSecrets(nil)
+
+	for _, value := range []string{"s3cr3ttoken", "s3cr3tpassword", "s3cr3tkey", "s3cr3toldkey", "s3cr3tsecretid"} {
+		assert.Equal(t, logging.Redacted, logging.Redact(value))
+	}
+}
+
+func TestWriteFileAtomicSymlink(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "pouch-test")
+	if err != nil {
+		t.Fatalf("couldn't create temporal directory")
+	}
+	defer os.RemoveAll(tmpdir)
+
+	target := path.Join(tmpdir, "target")
+	link := path.Join(tmpdir, "link")
+	if err := ioutil.WriteFile(target, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("target", link); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getuid() == 0 {
+		if err := os.Chown(target, 1, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err = writeFileAtomic(link, []byte("new"), 0640)
+	assert.NoError(t, err)
+
+	info, err := os.Lstat(link)
+	if assert.NoError(t, err) {
+		assert.True(t, info.Mode()&os.ModeSymlink != 0, "Symlink should be kept")
+	}
+	d, err := ioutil.ReadFile(target)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(d))
+
+	info, err = os.Stat(target)
+	if assert.NoError(t, err) {
+		assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+		if os.Getuid() == 0 {
+			stat := info.Sys().(*syscall.Stat_t)
+			assert.Equal(t, uint32(1), stat.Uid, "Owner should be kept")
+			assert.Equal(t, uint32(1), stat.Gid, "Group should be kept")
+		}
+	}
+}
@@ -17,10 +17,16 @@ limitations under the License.
 package pouch
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 
+	"github.com/tuenti/pouch/pkg/openrc"
+	"github.com/tuenti/pouch/pkg/runit"
+	"github.com/tuenti/pouch/pkg/s6"
+	"github.com/tuenti/pouch/pkg/supervisord"
+	"github.com/tuenti/pouch/pkg/systemd"
 	"github.com/tuenti/pouch/pkg/vault"
 
 	"github.com/ghodss/yaml"
@@ -29,9 +35,35 @@ import (
 type Pouchfile struct {
 	WrappedSecretIDPath string `json:"wrapped_secret_id_path,omitempty"`
 	StatePath           string `json:"state_path,omitempty"`
+	StrictTemplates     bool   `json:"strict_templates,omitempty"`
+
+	// Maximum time to wait for notifiers when stopping
+	ShutdownTimeout string `json:"shutdown_timeout,omitempty"`
+
+	// Notifier to run when a secret that cannot be updated is close to
+	// expire
+	OnExpiryWarning string `json:"on_expiry_warning,omitempty"`
+
+	Log LogConfig `json:"log,omitempty"`
+
+	Vault   vault.Config  `json:"vault,omitempty"`
+	Systemd SystemdConfig `json:"systemd,omitempty"`
+
+	// Service manager used by service notifiers, systemd by default
+	ServiceManager string            `json:"service_manager,omitempty"`
+	S6             S6Config          `json:"s6,omitempty"`
+	Runit          RunitConfig       `json:"runit,omitempty"`
+	Supervisord    SupervisordConfig `json:"supervisord,omitempty"`
+
+	// Listener for metrics and health checks, disabled if not set
+	Metrics *MetricsConfig `json:"metrics,omitempty"`
+
+	// Local control API, disabled if not set
+	Control *ControlConfig `json:"control,omitempty"`
+
+	// Audit trail, disabled if not set
+	Audit *AuditConfig `json:"audit,omitempty"`
 
-	Vault     vault.Config              `json:"vault,omitempty"`
-	Systemd   SystemdConfig             `json:"systemd,omitempty"`
 	Notifiers map[string]NotifierConfig `json:"notifiers,omitempty"`
 	Secrets   map[string]SecretConfig   `json:"secrets,omitempty"`
 	Files     []FileConfig              `json:"files,omitempty"`
@@ -57,6 +89,72 @@ func (s *SystemdConfig) Configurer() *systemdConfigurer {
 	}
 }
 
+type LogConfig struct {
+	// Minimum level of logged messages, info by default
+	Level string `json:"level,omitempty"`
+
+	// Format of logs, text or json
+	Format string `json:"format,omitempty"`
+}
+
+type MetricsConfig struct {
+	// TCP address to listen on
+	Address string `json:"address,omitempty"`
+
+	// Unix socket to listen on, instead of a TCP address
+	Socket string `json:"socket,omitempty"`
+}
+
+type ControlConfig struct {
+	// Unix socket to listen on
+	Socket string `json:"socket,omitempty"`
+
+	// Mode of the socket
+	Mode int `json:"mode,omitempty"`
+
+	// Users allowed to use the API, besides the one running pouch
+	UIDs []int `json:"uids,omitempty"`
+}
+
+type AuditConfig struct {
+	// File to append events to
+	File string `json:"file,omitempty"`
+
+	// Size in MB of the file before rotating it, and rotated files kept
+	MaxSize    int `json:"max_size,omitempty"`
+	MaxBackups int `json:"max_backups,omitempty"`
+
+	// Send events to syslog instead of to a file
+	Syslog *SyslogConfig `json:"syslog,omitempty"`
+
+	// File with the key used for digests of files, generated if it doesn't
+	// exist
+	DigestKeyFile string `json:"digest_key_file,omitempty"`
+}
+
+type SyslogConfig struct {
+	// Network and address of the syslog server, local one if not set
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+
+	Facility string `json:"facility,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+}
+
+type S6Config struct {
+	// Scan directory containing the services
+	ScanDir string `json:"scan_dir,omitempty"`
+}
+
+type RunitConfig struct {
+	// Directory containing the services, as SVDIR for sv
+	ServiceDir string `json:"service_dir,omitempty"`
+}
+
+type SupervisordConfig struct {
+	Socket string `json:"socket,omitempty"`
+}
+
 type SecretConfig struct {
 	VaultURL   string     `json:"vault_url,omitempty"`
 	HTTPMethod string     `json:"http_method,omitempty"`
@@ -70,13 +168,100 @@ type FileConfig struct {
 	TemplateFile string   `json:"template_file,omitempty"`
 	Notify       []string `json:"notify,omitempty"`
 	Priority     int      `json:"priority,omitempty"`
+
+	// Directory where each key of the secret is written as a file
+	Directory string `json:"directory,omitempty"`
+	Secret    string `json:"secret,omitempty"`
+
+	// Format to write the secret in, instead of using a template
+	Format string `json:"format,omitempty"`
+	// Keys of the secret to write, and the names to use for them
+	Keys map[string]string `json:"keys,omitempty"`
+	// Profile for AWS credentials files
+	Profile string `json:"profile,omitempty"`
+
+	// Password and alias for keystores, password can be a template
+	Password string `json:"password,omitempty"`
+	Alias    string `json:"alias,omitempty"`
+
+	// Decoding applied to the content before writing it
+	Decode string `json:"decode,omitempty"`
+}
+
+// Name returns the path of the file or directory to be written
+func (fc *FileConfig) Name() string {
+	if fc.Directory != "" {
+		return fc.Directory
+	}
+	return fc.Path
 }
 
 type NotifierConfig struct {
 	Command string `json:"command,omitempty"`
 	Service string `json:"service,omitempty"`
 
+	// Command executed without shell, and options for command notifiers
+	Args       []string          `json:"args,omitempty"`
+	WorkingDir string            `json:"working_dir,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	User       string            `json:"user,omitempty"`
+	Group      string            `json:"group,omitempty"`
+
+	// Action for service notifiers, and if they have to wait for the
+	// service to be active
+	Action     string `json:"action,omitempty"`
+	WaitActive bool   `json:"wait_active,omitempty"`
+
+	// Signal notifiers, processes are found by pidfile, executable
+	// name or command line
+	Signal  string `json:"signal,omitempty"`
+	Pidfile string `json:"pidfile,omitempty"`
+	Process string `json:"process,omitempty"`
+	Cmdline string `json:"cmdline,omitempty"`
+
+	Webhook *WebhookConfig `json:"webhook,omitempty"`
+	Docker  *DockerConfig  `json:"docker,omitempty"`
+
 	Timeout string `json:"timeout,omitempty"`
+
+	// Retry policy for failed notifications
+	Retries       int    `json:"retries,omitempty"`
+	RetryInterval string `json:"retry_interval,omitempty"`
+
+	// Notifier to run if this one fails after all retries
+	OnFailure string `json:"on_failure,omitempty"`
+
+	// Time to wait for more changes before running the notifier
+	Debounce string `json:"debounce,omitempty"`
+
+	// Minimum time between runs of the notifier
+	MinInterval string `json:"min_interval,omitempty"`
+
+	// Notifiers that have to run before this one when both are pending
+	After []string `json:"after,omitempty"`
+}
+
+type WebhookConfig struct {
+	URL string `json:"url,omitempty"`
+	// Unix socket to send requests to, instead of using TCP
+	Socket  string            `json:"socket,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Template for the body of the request, a JSON document with the
+	// notification is sent by default
+	Body           string `json:"body,omitempty"`
+	ExpectedStatus []int  `json:"expected_status,omitempty"`
+}
+
+type DockerConfig struct {
+	Container string `json:"container,omitempty"`
+	// One of kill, restart or exec
+	Action string `json:"action,omitempty"`
+	Signal string `json:"signal,omitempty"`
+	// Time to wait for the container to stop before killing it on restart
+	StopTimeout string   `json:"stop_timeout,omitempty"`
+	Command     []string `json:"command,omitempty"`
+	Socket      string   `json:"socket,omitempty"`
 }
 
 func LoadPouchfile(path string) (*Pouchfile, error) {
@@ -98,5 +283,67 @@ func loadPouchfile(r io.Reader) (*Pouchfile, error) {
 	if err != nil {
 		return nil, err
 	}
+	err = p.checkServiceActions()
+	if err != nil {
+		return nil, err
+	}
+	err = p.checkFileFormats()
+	if err != nil {
+		return nil, err
+	}
 	return &p, nil
 }
+
+// checkFileFormats checks that files use known formats and decodings, so
+// typos are found before requesting any secret
+func (p *Pouchfile) checkFileFormats() error {
+	for _, fc := range p.Files {
+		if fc.Format != "" {
+			_, isFile := fileFormatters[fc.Format]
+			_, isKeystore := keystoreFormatters[fc.Format]
+			if !isFile && !isKeystore {
+				return fmt.Errorf("unknown format for file %s: %s", fc.Name(), fc.Format)
+			}
+		}
+		if _, found := contentDecoders[fc.Decode]; fc.Decode != "" && !found {
+			return fmt.Errorf("unknown decoding for file %s: %s", fc.Name(), fc.Decode)
+		}
+	}
+	return nil
+}
+
+// Actions supported by each service manager
+var serviceManagerActions = map[string][]string{
+	"":            systemd.Actions,
+	"systemd":     systemd.Actions,
+	"openrc":      openrc.Actions,
+	"s6":          s6.Actions,
+	"runit":       runit.Actions,
+	"supervisord": supervisord.Actions,
+}
+
+// checkServiceActions checks that the actions of service notifiers are
+// supported by the service manager
+func (p *Pouchfile) checkServiceActions() error {
+	actions, found := serviceManagerActions[p.ServiceManager]
+	if !found {
+		return fmt.Errorf("unknown service manager: %s", p.ServiceManager)
+	}
+	for name, n := range p.Notifiers {
+		if n.Service == "" || n.Action == "" {
+			continue
+		}
+		supported := false
+		for _, action := range actions {
+			supported = supported || action == n.Action
+		}
+		if !supported {
+			manager := p.ServiceManager
+			if manager == "" {
+				manager = "systemd"
+			}
+			return fmt.Errorf("action '%s' of notifier '%s' is not supported by %s", n.Action, name, manager)
+		}
+	}
+	return nil
+}
@@ -128,3 +128,49 @@ func TestWrongPouchfile(t *testing.T) {
 		t.Fatal("Pouchfile load should have failed")
 	}
 }
+
+func TestCheckServiceActions(t *testing.T) {
+	cases := []struct {
+		pouchfile string
+		valid     bool
+	}{
+		{"notifiers: {nginx: {service: nginx, action: kill, signal: USR1}}", true},
+		{"service_manager: systemd\nnotifiers: {nginx: {service: nginx, action: try-restart}}", true},
+		{"service_manager: openrc\nnotifiers: {nginx: {service: nginx, action: reload-or-try-restart}}", true},
+		{"service_manager: openrc\nnotifiers: {nginx: {service: nginx, action: kill, signal: USR1}}", false},
+		{"service_manager: runit\nnotifiers: {nginx: {service: nginx, action: kill, signal: HUP}}", true},
+		{"service_manager: s6\nnotifiers: {nginx: {service: nginx, action: stop}}", false},
+		{"service_manager: unknown", false},
+	}
+	for _, c := range cases {
+		_, err := loadPouchfile(strings.NewReader(c.pouchfile))
+		if c.valid && err != nil {
+			t.Fatalf("pouchfile should be valid: %v\n%s", err, c.pouchfile)
+		}
+		if !c.valid && err == nil {
+			t.Fatalf("pouchfile shouldn't be valid:\n%s", c.pouchfile)
+		}
+	}
+}
+
+func TestCheckFileFormats(t *testing.T) {
+	cases := []struct {
+		pouchfile string
+		valid     bool
+	}{
+		{"files: [{path: /foo, secret: foo, format: dotenv}]", true},
+		{"files: [{path: /foo, secret: foo, format: pkcs12}]", true},
+		{"files: [{path: /foo, template: foo, decode: base64}]", true},
+		{"files: [{path: /foo, secret: foo, format: dotnev}]", false},
+		{"files: [{path: /foo, template: foo, decode: base32}]", false},
+	}
+	for _, c := range cases {
+		_, err := loadPouchfile(strings.NewReader(c.pouchfile))
+		if c.valid && err != nil {
+			t.Fatalf("pouchfile should be valid: %v\n%s", err, c.pouchfile)
+		}
+		if !c.valid && err == nil {
+			t.Fatalf("pouchfile shouldn't be valid:\n%s", c.pouchfile)
+		}
+	}
+}
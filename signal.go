@@ -0,0 +1,166 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	DefaultNotifySignal = syscall.SIGHUP
+
+	procPath = "/proc"
+)
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"STOP":  syscall.SIGSTOP,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// parseSignal parses a signal by its name, with or without SIG prefix,
+// or by its number
+func parseSignal(name string) (syscall.Signal, error) {
+	if name == "" {
+		return DefaultNotifySignal, nil
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		return syscall.Signal(n), nil
+	}
+	signal, found := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !found {
+		return 0, fmt.Errorf("unknown signal: %s", name)
+	}
+	return signal, nil
+}
+
+type SignalNotifier struct {
+	Signal syscall.Signal
+
+	// Process can be found by its pidfile, by its executable name, or
+	// with a regular expression matching its command line
+	Pidfile string
+	Process string
+	Cmdline *regexp.Regexp
+}
+
+func (n *SignalNotifier) Run(ctx context.Context, _ *Notification) (string, error) {
+	pids, err := n.findProcesses()
+	if err != nil {
+		return "", err
+	}
+	var out []string
+	for _, pid := range pids {
+		err := syscall.Kill(pid, n.Signal)
+		if err != nil {
+			return strings.Join(out, "\n"), fmt.Errorf("couldn't send %s to process %d: %v", n.Signal, pid, err)
+		}
+		out = append(out, fmt.Sprintf("Sent %s to process %d", n.Signal, pid))
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+func (n *SignalNotifier) findProcesses() ([]int, error) {
+	if n.Pidfile != "" {
+		pid, err := pidFromFile(n.Pidfile)
+		if err != nil {
+			return nil, err
+		}
+		// Check that the process exists
+		if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+			return nil, fmt.Errorf("process %d from pidfile %s is not running", pid, n.Pidfile)
+		}
+		return []int{pid}, nil
+	}
+
+	entries, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if n.matchProcess(pid) {
+			pids = append(pids, pid)
+		}
+	}
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("no running process found matching %s", n.description())
+	}
+	return pids, nil
+}
+
+func (n *SignalNotifier) description() string {
+	if n.Process != "" {
+		return fmt.Sprintf("executable name '%s'", n.Process)
+	}
+	return fmt.Sprintf("command line '%s'", n.Cmdline)
+}
+
+func (n *SignalNotifier) matchProcess(pid int) bool {
+	dir := filepath.Join(procPath, strconv.Itoa(pid))
+	d, err := ioutil.ReadFile(filepath.Join(dir, "cmdline"))
+	if err != nil || len(d) == 0 {
+		// Process has finished, or it is a kernel thread
+		return false
+	}
+	args := strings.Split(string(bytes.TrimRight(d, "\x00")), "\x00")
+
+	if n.Cmdline != nil {
+		return n.Cmdline.MatchString(strings.Join(args, " "))
+	}
+
+	if filepath.Base(args[0]) == n.Process {
+		return true
+	}
+	if exe, err := os.Readlink(filepath.Join(dir, "exe")); err == nil && filepath.Base(exe) == n.Process {
+		return true
+	}
+	comm, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	return err == nil && strings.TrimSpace(string(comm)) == n.Process
+}
+
+func pidFromFile(path string) (int, error) {
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't read pidfile: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(d)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("incorrect pid in %s: %s", path, d)
+	}
+	return pid, nil
+}
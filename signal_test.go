@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var parseSignalCases = []struct {
+	Name   string
+	Signal syscall.Signal
+	Error  bool
+}{
+	{"", syscall.SIGHUP, false},
+	{"HUP", syscall.SIGHUP, false},
+	{"SIGUSR1", syscall.SIGUSR1, false},
+	{"usr2", syscall.SIGUSR2, false},
+	{"15", syscall.SIGTERM, false},
+	{"FOO", 0, true},
+}
+
+func TestParseSignal(t *testing.T) {
+	for _, c := range parseSignalCases {
+		signal, err := parseSignal(c.Name)
+		if c.Error {
+			assert.Error(t, err, c.Name)
+			continue
+		}
+		if assert.NoError(t, err, c.Name) {
+			assert.Equal(t, c.Signal, signal)
+		}
+	}
+}
+
+func TestSignalNotifier(t *testing.T) {
+	cmd := exec.Command("sleep", "60")
+	err := cmd.Start()
+	if err != nil {
+		t.Skipf("couldn't start process: %v", err)
+	}
+	defer cmd.Process.Kill()
+
+	pidfile, err := ioutil.TempFile("", "pouch-test-pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(pidfile.Name())
+	fmt.Fprintf(pidfile, "%d\n", cmd.Process.Pid)
+	pidfile.Close()
+
+	p := NewPouch(nil, nil, nil, nil, nil).(*pouch)
+	runner, err := p.notifierRunner(NotifierConfig{Pidfile: pidfile.Name(), Signal: "USR1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = runner.Run(context.Background(), &Notification{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); assert.True(t, ok) {
+		status := exitErr.Sys().(syscall.WaitStatus)
+		assert.Equal(t, syscall.SIGUSR1, status.Signal())
+	}
+
+	_, err = runner.Run(context.Background(), &Notification{})
+	assert.Error(t, err, "Finished process shouldn't be found")
+
+	runner, err = p.notifierRunner(NotifierConfig{Process: "pouch-test-not-running"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = runner.Run(context.Background(), &Notification{})
+	assert.Error(t, err, "Not running process shouldn't be found")
+
+	_, err = p.notifierRunner(NotifierConfig{Process: "foo", Pidfile: "/foo"})
+	assert.Error(t, err, "Only one method to find the process should be allowed")
+}
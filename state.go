@@ -22,12 +22,13 @@ import (
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
 	"time"
 
+	"github.com/tuenti/pouch/pkg/logging"
+
 	"github.com/hashicorp/vault/api"
 )
 
@@ -47,6 +48,12 @@ type PouchState struct {
 	// Secrets state
 	Secrets map[string]*SecretState `json:"secrets,omitempty"`
 
+	// Result of the last run of each notifier
+	Notifiers map[string]*NotifierState `json:"notifiers,omitempty"`
+
+	// Files written
+	Files map[string]*FileState `json:"files,omitempty"`
+
 	// Path from where this state was read
 	Path string `json:"-"`
 }
@@ -107,13 +114,14 @@ func (s *PouchState) Save() error {
 	return ioutil.WriteFile(path, d, DefaultStateMode)
 }
 
-// Sources of TTUs
-var secretTTUSources = []func(*SecretState) (*time.Time, error){
+// Sources of TTUs, they return the time when the given ratio of the
+// validity of a secret has passed
+var secretTTUSources = []func(*SecretState, float64) (*time.Time, error){
 	ttuFromTTLOrLeaseDuration,
 	ttuFromCertificateValidity,
 }
 
-func ttuFromTTLOrLeaseDuration(s *SecretState) (*time.Time, error) {
+func ttuFromTTLOrLeaseDuration(s *SecretState, ratio float64) (*time.Time, error) {
 	ttl, ttlKnown := s.TTL()
 
 	var duration int
@@ -132,11 +140,11 @@ func ttuFromTTLOrLeaseDuration(s *SecretState) (*time.Time, error) {
 		return nil, nil
 	}
 
-	ttu := s.Timestamp.Add(time.Duration(float64(duration)*s.Ratio()) * time.Second)
+	ttu := s.Timestamp.Add(time.Duration(float64(duration)*ratio) * time.Second)
 	return &ttu, nil
 }
 
-func ttuFromCertificateValidity(s *SecretState) (*time.Time, error) {
+func ttuFromCertificateValidity(s *SecretState, ratio float64) (*time.Time, error) {
 	if s.Data == nil {
 		return nil, nil
 	}
@@ -157,7 +165,7 @@ func ttuFromCertificateValidity(s *SecretState) (*time.Time, error) {
 	}
 
 	ttl := certificate.NotAfter.Sub(certificate.NotBefore)
-	ttu := certificate.NotBefore.Add(time.Duration(float64(ttl) * s.Ratio()))
+	ttu := certificate.NotBefore.Add(time.Duration(float64(ttl) * ratio))
 	return &ttu, nil
 }
 
@@ -179,20 +187,57 @@ func (s *PouchState) SetSecret(name string, secret *api.Secret) {
 
 	if oldState, found := s.Secrets[name]; found {
 		state.FilesUsing = oldState.FilesUsing
+		state.Config = oldState.Config
 	}
 	s.Secrets[name] = state
 }
 
+// SetNotifierResult records the result of running a notifier
+func (s *PouchState) SetNotifierResult(name string, err error) {
+	if s.Notifiers == nil {
+		s.Notifiers = make(map[string]*NotifierState)
+	}
+	state, found := s.Notifiers[name]
+	if !found {
+		state = &NotifierState{}
+		s.Notifiers[name] = state
+	}
+	state.LastRun = time.Now()
+	if err != nil {
+		state.LastError = err.Error()
+		state.Failures++
+		return
+	}
+	state.LastError = ""
+	state.Failures = 0
+	state.LastSuccess = state.LastRun
+}
+
+// SetFileWritten records that a file has been written
+func (s *PouchState) SetFileWritten(path string) {
+	if s.Files == nil {
+		s.Files = make(map[string]*FileState)
+	}
+	s.Files[path] = &FileState{LastWrite: time.Now()}
+}
+
 func (s *PouchState) DeleteSecret(name string) {
 	delete(s.Secrets, name)
 }
 
+func (s *PouchState) DeleteNotifier(name string) {
+	delete(s.Notifiers, name)
+}
+
 func (s *PouchState) NextUpdate() (secret *SecretState, minTTU time.Time) {
 	for name := range s.Secrets {
 		if s.Secrets[name].DisableAutoUpdate {
 			continue
 		}
 		ttu, known := s.Secrets[name].TimeToUpdate()
+		if retry := s.Secrets[name].retryTime; retry.After(ttu) {
+			ttu = retry
+		}
 		if known && (secret == nil || ttu.Before(minTTU)) {
 			secret = s.Secrets[name]
 			minTTU = ttu
@@ -228,6 +273,18 @@ func (s *PriorityFileSortedList) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Add adds a file to the list if it is not already there, keeping it sorted
+func (p *PriorityFileSortedList) Add(file PriorityFile) {
+	for _, f := range *p {
+		if f.Path == file.Path {
+			// Already registered
+			return
+		}
+	}
+	*p = append(*p, file)
+	sort.Sort(*p)
+}
+
 func (p PriorityFileSortedList) Len() int      { return len(p) }
 func (p PriorityFileSortedList) Swap(i, j int) { p[i], p[j] = p[j], p[i] }
 func (p PriorityFileSortedList) Less(i, j int) bool {
@@ -260,6 +317,16 @@ type SecretState struct {
 
 	// Files using this secret
 	FilesUsing PriorityFileSortedList `json:"files_using,omitempty"`
+
+	// Configuration of secrets read from templates, not declared
+	// in the Pouchfile, without their data
+	Config *SecretConfig `json:"config,omitempty"`
+
+	// Level of the last expiry warning, since the secret was read
+	ExpiryWarnings int `json:"expiry_warnings,omitempty"`
+
+	// Time of the next attempt to update the secret, if the last one failed
+	retryTime time.Time
 }
 
 func (s *SecretState) Ratio() float64 {
@@ -289,28 +356,54 @@ func (s *SecretState) TTL() (int, bool) {
 	return 0, false
 }
 
-func (s *SecretState) TimeToUpdate() (minTTU time.Time, known bool) {
+func (s *SecretState) TimeToUpdate() (time.Time, bool) {
+	return s.timeAtRatio(s.Ratio())
+}
+
+// Expiry returns the time when the secret is not valid anymore
+func (s *SecretState) Expiry() (time.Time, bool) {
+	return s.timeAtRatio(1)
+}
+
+func (s *SecretState) timeAtRatio(ratio float64) (min time.Time, known bool) {
 	for _, source := range secretTTUSources {
-		ttu, err := source(s)
+		t, err := source(s, ratio)
 		if err != nil {
-			log.Printf("Error trying to obtain TTU for secret '%s': %s", s.Name, err)
+			logging.WithField(logging.SecretField, s.Name).Warnf("Error trying to obtain TTU: %s", err)
 			continue
 		}
-		if ttu != nil && (!known || ttu.Before(minTTU)) {
-			minTTU = *ttu
+		if t != nil && (!known || t.Before(min)) {
+			min = *t
 			known = true
 		}
 	}
 	return
 }
 
+// RetryAfter delays the next update of the secret, after a failed one
+func (s *SecretState) RetryAfter(d time.Duration) {
+	s.retryTime = time.Now().Add(d)
+}
+
 func (s *SecretState) RegisterUsage(path string, priority int) {
-	for _, f := range s.FilesUsing {
-		if f.Path == path {
-			// Already registered
-			return
-		}
-	}
-	s.FilesUsing = append(s.FilesUsing, PriorityFile{Priority: priority, Path: path})
-	sort.Sort(s.FilesUsing)
+	s.FilesUsing.Add(PriorityFile{Priority: priority, Path: path})
+}
+
+type NotifierState struct {
+	// Time of the last run of the notifier, successful or not
+	LastRun time.Time `json:"last_run,omitempty"`
+
+	// Time of the last successful run of the notifier
+	LastSuccess time.Time `json:"last_success,omitempty"`
+
+	// Error of the last run, if it failed
+	LastError string `json:"last_error,omitempty"`
+
+	// Number of consecutive failed runs
+	Failures int `json:"failures,omitempty"`
+}
+
+type FileState struct {
+	// Time of the last write of the file
+	LastWrite time.Time `json:"last_write,omitempty"`
 }
@@ -260,3 +260,24 @@ func TestConsistentTTU(t *testing.T) {
 		}
 	}
 }
+
+var secretExpiryCases = []struct {
+	Secret *SecretState
+	Expiry time.Time
+	Known  bool
+}{
+	{unknownTTL, time.Time{}, false},
+	{secretCaseTTL, time.Time{}.Add(360 * time.Second), true},
+	{secretWithCertificate, testCertNotBefore.Add(24 * time.Hour), true},
+	{secretBeforeCertificate, testCertNotBefore.Add(60 * time.Second), true},
+	{secretAfterCertificate, testCertNotAfter.Add(60 * time.Second), true},
+}
+
+func TestSecretExpiry(t *testing.T) {
+	for i, c := range secretExpiryCases {
+		expiry, known := c.Secret.Expiry()
+		if known != c.Known || expiry != c.Expiry {
+			t.Fatalf("Case #%d: found expiry %s (%t), expected %s (%t)", i, expiry, known, c.Expiry, c.Known)
+		}
+	}
+}
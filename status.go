@@ -0,0 +1,115 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tuenti/pouch/pkg/metrics"
+)
+
+// Status summarizes the state of pouch
+type Status struct {
+	// All secrets and files have been provisioned once
+	Ready bool `json:"ready"`
+
+	// Notifiers whose last run failed
+	FailingNotifiers []string `json:"failing_notifiers,omitempty"`
+
+	// Secrets that couldn't be updated and are close to expire, or
+	// already expired
+	ExpiringSecrets []string `json:"expiring_secrets,omitempty"`
+}
+
+// Healthy returns true if pouch is ready and nothing is failing
+func (s Status) Healthy() bool {
+	return s.Ready && len(s.FailingNotifiers) == 0 && len(s.ExpiringSecrets) == 0
+}
+
+func (s Status) String() string {
+	if !s.Ready {
+		return "not ready"
+	}
+	var problems []string
+	if len(s.ExpiringSecrets) > 0 {
+		problems = append(problems, fmt.Sprintf("secrets close to expiry: %s", strings.Join(s.ExpiringSecrets, ", ")))
+	}
+	if len(s.FailingNotifiers) > 0 {
+		problems = append(problems, fmt.Sprintf("failing notifiers: %s", strings.Join(s.FailingNotifiers, ", ")))
+	}
+	if len(problems) > 0 {
+		return strings.Join(problems, "; ")
+	}
+	return "ready"
+}
+
+// Status returns the last published status, it is safe to call it while
+// pouch is running
+func (p *pouch) Status() Status {
+	p.statusLock.Lock()
+	defer p.statusLock.Unlock()
+	return p.status
+}
+
+// updateStatus publishes the current status
+func (p *pouch) updateStatus() {
+	status := p.currentStatus()
+	p.statusLock.Lock()
+	p.status = status
+	p.statusLock.Unlock()
+
+	ready := 0.0
+	if status.Ready {
+		ready = 1
+	}
+	metrics.SetGauge(metricReady, nil, ready)
+}
+
+func (p *pouch) currentStatus() Status {
+	status := Status{Ready: p.ready}
+	if p.State != nil {
+		for name, n := range p.State.Notifiers {
+			if n.Failures > 0 {
+				status.FailingNotifiers = append(status.FailingNotifiers, name)
+			}
+		}
+		for name, s := range p.State.Secrets {
+			if s.ExpiryWarnings > 0 {
+				status.ExpiringSecrets = append(status.ExpiringSecrets, name)
+			}
+		}
+	}
+	sort.Strings(status.FailingNotifiers)
+	sort.Strings(status.ExpiringSecrets)
+	return status
+}
+
+// statusSummary describes the provisioned secrets and files and the next
+// secret update
+func (p *pouch) statusSummary(next *SecretState, ttu time.Time) string {
+	summary := fmt.Sprintf("%d secrets and %d files provisioned", len(p.State.Secrets), len(p.Files))
+	if next != nil {
+		summary += fmt.Sprintf(", next rotation of '%s' at %s", next.Name, ttu.Format(time.RFC3339))
+	}
+	if status := p.currentStatus(); !status.Healthy() {
+		summary = status.String() + "; " + summary
+	}
+	return summary
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type dummyStatusNotifier struct {
+	Notifications []string
+	Watchdog      time.Duration
+}
+
+func (n *dummyStatusNotifier) NotifyReady() error {
+	n.Notifications = append(n.Notifications, "READY")
+	return nil
+}
+
+func (n *dummyStatusNotifier) NotifyStatus(status string) error {
+	n.Notifications = append(n.Notifications, "STATUS="+status)
+	return nil
+}
+
+func (n *dummyStatusNotifier) NotifyWatchdog() error {
+	n.Notifications = append(n.Notifications, "WATCHDOG")
+	return nil
+}
+
+func (n *dummyStatusNotifier) NotifyReloading() error {
+	n.Notifications = append(n.Notifications, "RELOADING")
+	return nil
+}
+
+func (n *dummyStatusNotifier) NotifyStopping() error {
+	n.Notifications = append(n.Notifications, "STOPPING")
+	return nil
+}
+
+func (n *dummyStatusNotifier) WatchdogInterval() time.Duration {
+	return n.Watchdog
+}
+
+func TestStatusNotifiers(t *testing.T) {
+	state, cleanup := newTestState()
+	defer cleanup()
+	p := NewPouch(state, nil, nil, nil, nil).(*pouch)
+
+	n1 := &dummyStatusNotifier{Watchdog: 30 * time.Second}
+	n2 := &dummyStatusNotifier{Watchdog: 10 * time.Second}
+	n3 := &dummyStatusNotifier{}
+	p.AddStatusNotifier(n1)
+	p.AddStatusNotifier(n2)
+	p.AddStatusNotifier(n3)
+	assert.Equal(t, 10*time.Second, p.watchdogInterval())
+
+	p.NotifyStatus("Waiting for %s", "foo")
+	p.NotifyStatus("Waiting for %s", "foo")
+	p.NotifyReady()
+	p.NotifyStatus("%s", p.statusSummary(nil, time.Time{}))
+	state.SetNotifierResult("nginx", errors.New("failed"))
+	p.NotifyStatus("%s", p.statusSummary(nil, time.Time{}))
+	p.NotifyStopping()
+
+	expected := []string{
+		"STATUS=Waiting for foo",
+		"READY",
+		"STATUS=0 secrets and 0 files provisioned",
+		"STATUS=failing notifiers: nginx; 0 secrets and 0 files provisioned",
+		"STOPPING",
+	}
+	for _, n := range []*dummyStatusNotifier{n1, n2, n3} {
+		assert.Equal(t, expected, n.Notifications)
+	}
+}
+
+func TestStatusRemovedNotifiers(t *testing.T) {
+	state, cleanup := newTestState()
+	defer cleanup()
+	state.SetNotifierResult("removed", errors.New("failed"))
+	state.SetNotifierResult("nginx", nil)
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(state.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &DummyVault{T: t, Token: "token", ExpectedToken: "token"}
+	notifiers := map[string]NotifierConfig{
+		"nginx": {Command: "true"},
+	}
+	p := NewPouch(state, v, nil, nil, notifiers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.NoError(t, p.Run(ctx))
+
+	assert.True(t, p.Status().Healthy(), "Notifiers not configured shouldn't be reported as failing")
+	assert.NotContains(t, state.Notifiers, "removed")
+	assert.Contains(t, state.Notifiers, "nginx")
+}
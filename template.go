@@ -0,0 +1,228 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/tuenti/pouch/pkg/logging"
+)
+
+// Reference to a secret key found in a template
+type secretReference struct {
+	Secret string
+	Key    string
+}
+
+func parseFileTemplate(fc FileConfig, funcMap template.FuncMap) (*template.Template, error) {
+	if fc.Template != "" && fc.TemplateFile != "" {
+		return nil, fmt.Errorf("inline template and template file specified")
+	}
+	switch {
+	case fc.Template != "":
+		return template.New("inline-template").Funcs(funcMap).Parse(fc.Template)
+	case fc.TemplateFile != "":
+		d, err := ioutil.ReadFile(fc.TemplateFile)
+		if err != nil {
+			return nil, err
+		}
+		return template.New(fc.TemplateFile).Funcs(funcMap).Parse(string(d))
+	default:
+		return nil, fmt.Errorf("no content defined for file %s", fc.Path)
+	}
+}
+
+// Functions available in file templates, with dummy implementations,
+// only intended to be used to parse templates for analysis
+var analysisFuncMap = template.FuncMap{
+	"secret": func(string, string) (interface{}, error) { return nil, nil },
+	"vault":  func(string, ...string) (SecretData, error) { return nil, nil },
+}
+
+// templateSecretReferences finds all calls to the secret function with
+// constant arguments. Calls using variables or pipelines as arguments
+// cannot be statically checked and are ignored.
+func templateSecretReferences(t *template.Template) []secretReference {
+	var refs []secretReference
+	for _, t := range t.Templates() {
+		if t.Tree != nil {
+			refs = append(refs, nodeSecretReferences(t.Tree.Root)...)
+		}
+	}
+	return refs
+}
+
+func nodeSecretReferences(node parse.Node) (refs []secretReference) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, child := range n.Nodes {
+			refs = append(refs, nodeSecretReferences(child)...)
+		}
+	case *parse.ActionNode:
+		refs = nodeSecretReferences(n.Pipe)
+	case *parse.IfNode:
+		refs = branchSecretReferences(&n.BranchNode)
+	case *parse.RangeNode:
+		refs = branchSecretReferences(&n.BranchNode)
+	case *parse.WithNode:
+		refs = branchSecretReferences(&n.BranchNode)
+	case *parse.TemplateNode:
+		refs = nodeSecretReferences(n.Pipe)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, cmd := range n.Cmds {
+			refs = append(refs, nodeSecretReferences(cmd)...)
+		}
+	case *parse.CommandNode:
+		if ref, ok := commandSecretReference(n); ok {
+			refs = append(refs, ref)
+		}
+		for _, arg := range n.Args {
+			refs = append(refs, nodeSecretReferences(arg)...)
+		}
+	}
+	return refs
+}
+
+func branchSecretReferences(n *parse.BranchNode) (refs []secretReference) {
+	refs = append(refs, nodeSecretReferences(n.Pipe)...)
+	refs = append(refs, nodeSecretReferences(n.List)...)
+	refs = append(refs, nodeSecretReferences(n.ElseList)...)
+	return refs
+}
+
+func commandSecretReference(n *parse.CommandNode) (secretReference, bool) {
+	if len(n.Args) != 3 {
+		return secretReference{}, false
+	}
+	if f, ok := n.Args[0].(*parse.IdentifierNode); !ok || f.Ident != "secret" {
+		return secretReference{}, false
+	}
+	name, ok := n.Args[1].(*parse.StringNode)
+	if !ok {
+		return secretReference{}, false
+	}
+	key, ok := n.Args[2].(*parse.StringNode)
+	if !ok {
+		return secretReference{}, false
+	}
+	return secretReference{Secret: name.Text, Key: key.Text}, true
+}
+
+// fileSecretReferences parses the templates of all files and returns the
+// secret references found on each one of them
+func (p *pouch) fileSecretReferences() (map[string][]secretReference, []string) {
+	var problems []string
+	refs := make(map[string][]secretReference)
+	for path, fc := range p.Files {
+		if fc.Directory != "" || fc.Format != "" {
+			refs[path] = fc.secretReferences()
+			if fc.Password == "" {
+				continue
+			}
+			// Password of keystores can be a template
+			fc = FileConfig{Path: fc.Path, Template: fc.Password}
+		}
+		t, err := parseFileTemplate(fc, analysisFuncMap)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("couldn't parse template for '%s': %v", path, err))
+			continue
+		}
+		refs[path] = append(refs[path], templateSecretReferences(t)...)
+	}
+	return refs, problems
+}
+
+// checkTemplates statically checks that all secrets used in templates are
+// declared, it also warns about declared secrets not used by any file
+func (p *pouch) checkTemplates() error {
+	refs, problems := p.fileSecretReferences()
+
+	used := make(map[string]bool)
+	for path, fileRefs := range refs {
+		for _, ref := range fileRefs {
+			used[ref.Secret] = true
+			if _, found := p.Secrets[ref.Secret]; !found {
+				problems = append(problems, fmt.Sprintf("file '%s' uses undeclared secret '%s'", path, ref.Secret))
+			}
+		}
+	}
+
+	dependencies, err := p.secretDependencies()
+	if err != nil {
+		return err
+	}
+	for _, deps := range dependencies {
+		for _, dep := range deps {
+			used[dep] = true
+		}
+	}
+
+	for name := range p.Secrets {
+		if !used[name] {
+			logging.WithField(logging.SecretField, name).Warnf("Secret is not used by any file")
+		}
+	}
+
+	return p.templateProblems(problems)
+}
+
+// checkSecretKeys checks that all keys used in templates are available in
+// the secrets retrieved
+func (p *pouch) checkSecretKeys() error {
+	refs, problems := p.fileSecretReferences()
+
+	for path, fileRefs := range refs {
+		for _, ref := range fileRefs {
+			secret, found := p.State.Secrets[ref.Secret]
+			if !found || ref.Key == "" {
+				continue
+			}
+			if _, found := secret.Data[ref.Key]; !found {
+				problems = append(problems, fmt.Sprintf("file '%s' uses unknown key '%s' of secret '%s'", path, ref.Key, ref.Secret))
+			}
+		}
+	}
+
+	return p.templateProblems(problems)
+}
+
+// templateProblems logs found problems, and returns them as an error if
+// strict templates mode is enabled
+func (p *pouch) templateProblems(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	if p.strictTemplates {
+		return fmt.Errorf("problems found in templates: %s", strings.Join(problems, "; "))
+	}
+	for _, problem := range problems {
+		logging.Warnf("Template problem: %s", problem)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var templateReferencesCases = []struct {
+	Template   string
+	References []secretReference
+}{
+	{`{{ secret "foo" "bar" }}`, []secretReference{{"foo", "bar"}}},
+	{
+		`{{ secret "foo" "bar" }} {{ secret "foo" "baz" | printf "%s" }}`,
+		[]secretReference{{"foo", "bar"}, {"foo", "baz"}},
+	},
+	{
+		`{{ if secret "a" "b" }}{{ secret "c" "d" }}{{ else }}{{ secret "e" "f" }}{{ end }}`,
+		[]secretReference{{"a", "b"}, {"c", "d"}, {"e", "f"}},
+	},
+	{
+		`{{ define "t" }}{{ secret "a" "b" }}{{ end }}{{ template "t" }}`,
+		[]secretReference{{"a", "b"}},
+	},
+	{`{{ with $k := "b" }}{{ secret "a" $k }}{{ end }}`, nil},
+	{`{{ printf "%s" (secret "a" "b") }}`, []secretReference{{"a", "b"}}},
+}
+
+func TestTemplateSecretReferences(t *testing.T) {
+	for _, c := range templateReferencesCases {
+		tmpl, err := parseFileTemplate(FileConfig{Template: c.Template}, analysisFuncMap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, c.References, templateSecretReferences(tmpl), c.Template)
+	}
+}
+
+func TestCheckTemplates(t *testing.T) {
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/foo"},
+	}
+	files := []FileConfig{
+		{Path: "/foo", Template: `{{ secret "foo" "foo" }}`},
+		{Path: "/bar", Template: `{{ secret "bar" "foo" }}`},
+	}
+
+	p := NewPouch(nil, nil, secrets, files, nil).(*pouch)
+	assert.NoError(t, p.checkTemplates(), "Problems shouldn't be errors if not in strict mode")
+
+	p.StrictTemplates(true)
+	assert.Error(t, p.checkTemplates(), "Undeclared secret should be detected")
+
+	delete(p.Files, "/bar")
+	assert.NoError(t, p.checkTemplates())
+}
+
+func TestCheckSecretKeys(t *testing.T) {
+	secrets := map[string]SecretConfig{
+		"foo": {VaultURL: "/v1/foo"},
+	}
+	files := []FileConfig{
+		{Path: "/foo", Template: `{{ secret "foo" "foo" }}`},
+		{Path: "/bar", Template: `{{ secret "foo" "bar" }}`},
+	}
+
+	state := NewState("")
+	state.Secrets = map[string]*SecretState{
+		"foo": {Name: "foo", Data: SecretData{"foo": "secret"}},
+	}
+
+	p := NewPouch(state, nil, secrets, files, nil).(*pouch)
+	p.StrictTemplates(true)
+	assert.Error(t, p.checkSecretKeys(), "Unknown key should be detected")
+
+	state.Secrets["foo"].Data["bar"] = "secret"
+	assert.NoError(t, p.checkSecretKeys())
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	DefaultPouchUnit   = "pouch.service"
+	DefaultUnitsDir    = "/etc/systemd/system"
+	UnitDropInFileName = "pouch.conf"
+
+	generatedUnitHeader = "# Generated by pouch generate-units, don't edit\n"
+)
+
+type UnitsOptions struct {
+	// Name of the unit running pouch
+	PouchUnit string
+}
+
+// unitName returns the name of a systemd unit, services can be referenced
+// without suffix
+func unitName(service string) string {
+	if filepath.Ext(service) == "" {
+		return service + ".service"
+	}
+	return service
+}
+
+// unitFiles returns the files provisioned by pouch that are notified to
+// each unit used by service notifiers
+func (pf *Pouchfile) unitFiles() map[string][]string {
+	notifierUnits := make(map[string]string)
+	for name, n := range pf.Notifiers {
+		if n.Service != "" {
+			notifierUnits[name] = unitName(n.Service)
+		}
+	}
+
+	units := make(map[string][]string)
+	for _, unit := range notifierUnits {
+		units[unit] = nil
+	}
+	for _, fc := range pf.Files {
+		for _, notifier := range fc.Notify {
+			if unit, found := notifierUnits[notifier]; found {
+				units[unit] = addSorted(units[unit], fc.Name())
+			}
+		}
+	}
+	return units
+}
+
+// GenerateUnits generates systemd drop-ins for the units used by service
+// notifiers, so they are started after pouch and only if the files they
+// need exist. Units only want pouch, so they are not stopped or restarted
+// with it. It returns the content of the files by their path relative
+// to the units directory.
+func GenerateUnits(pf *Pouchfile, options UnitsOptions) (map[string]string, error) {
+	if pf.ServiceManager != "" && pf.ServiceManager != "systemd" {
+		return nil, fmt.Errorf("units can only be generated for systemd, found: %s", pf.ServiceManager)
+	}
+	pouchUnit := options.PouchUnit
+	if pouchUnit == "" {
+		pouchUnit = DefaultPouchUnit
+	}
+	pouchUnit = unitName(pouchUnit)
+
+	generated := make(map[string]string)
+	for unit, files := range pf.unitFiles() {
+		if unit == pouchUnit {
+			return nil, fmt.Errorf("pouch unit %s cannot be notified by itself", unit)
+		}
+
+		var b bytes.Buffer
+		b.WriteString(generatedUnitHeader)
+		b.WriteString("[Unit]\n")
+		fmt.Fprintf(&b, "After=%s\n", pouchUnit)
+		fmt.Fprintf(&b, "Wants=%s\n", pouchUnit)
+		for _, f := range files {
+			path, err := unitPath(f)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, "ConditionPathExists=%s\n", path)
+		}
+		generated[filepath.Join(unit+".d", UnitDropInFileName)] = b.String()
+	}
+	return generated, nil
+}
+
+// unitPath escapes a path to be used as value in unit files, only
+// specifiers need to be escaped, as paths are not unescaped by systemd
+func unitPath(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("path %q cannot be used in units, it is not absolute", path)
+	}
+	if strings.ContainsAny(path, "\n\r") || strings.HasSuffix(path, `\`) || strings.TrimSpace(path) != path {
+		return "", fmt.Errorf("path %q cannot be used in units", path)
+	}
+	return strings.Replace(path, "%", "%%", -1), nil
+}
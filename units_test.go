@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var unitsPouchfile = &Pouchfile{
+	Notifiers: map[string]NotifierConfig{
+		"nginx":   {Service: "nginx"},
+		"kubelet": {Service: "kubelet.service", Action: "restart"},
+		"unused":  {Service: "unused"},
+		"command": {Command: "true"},
+	},
+	Files: []FileConfig{
+		{Path: "/etc/nginx/ssl/cert.pem", Notify: []string{"nginx", "command"}},
+		{Path: "/etc/nginx/ssl/key.pem", Notify: []string{"nginx"}},
+		{Path: "/etc/kubernetes/my ca%1.crt", Notify: []string{"kubelet"}},
+	},
+}
+
+func TestGenerateUnits(t *testing.T) {
+	units, err := GenerateUnits(unitsPouchfile, UnitsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"nginx.service.d/pouch.conf": generatedUnitHeader + `[Unit]
+After=pouch.service
+Wants=pouch.service
+ConditionPathExists=/etc/nginx/ssl/cert.pem
+ConditionPathExists=/etc/nginx/ssl/key.pem
+`,
+		"kubelet.service.d/pouch.conf": generatedUnitHeader + `[Unit]
+After=pouch.service
+Wants=pouch.service
+ConditionPathExists=/etc/kubernetes/my ca%%1.crt
+`,
+		"unused.service.d/pouch.conf": generatedUnitHeader + `[Unit]
+After=pouch.service
+Wants=pouch.service
+`,
+	}
+	assert.Equal(t, expected, units)
+
+	_, err = GenerateUnits(&Pouchfile{ServiceManager: "openrc"}, UnitsOptions{})
+	assert.Error(t, err, "Units can only be generated for systemd")
+}
+
+func TestGenerateUnitsInvalidPaths(t *testing.T) {
+	paths := []string{
+		"relative/cert.pem",
+		"/etc/nginx/cert\npem",
+		"/etc/nginx/cert.pem ",
+		`/etc/nginx/cert.pem\`,
+	}
+	for _, path := range paths {
+		pf := &Pouchfile{
+			Notifiers: map[string]NotifierConfig{"nginx": {Service: "nginx"}},
+			Files:     []FileConfig{{Path: path, Notify: []string{"nginx"}}},
+		}
+		_, err := GenerateUnits(pf, UnitsOptions{})
+		assert.Error(t, err, "Path %q cannot be used in units", path)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+const (
+	DefaultWebhookMethod = "POST"
+
+	// Host used in URLs of requests sent to Unix sockets when no URL is set
+	webhookSocketURL = "http://localhost/"
+
+	// Maximum size of responses to include in notifier output
+	webhookMaxOutput = 4096
+)
+
+type WebhookNotifier struct {
+	Client         *http.Client
+	URL            string
+	Method         string
+	Headers        map[string]string
+	Body           *template.Template
+	ExpectedStatus []int
+}
+
+func newWebhookNotifier(config *WebhookConfig) (*WebhookNotifier, error) {
+	n := &WebhookNotifier{
+		Client:         &http.Client{},
+		URL:            config.URL,
+		Method:         strings.ToUpper(config.Method),
+		Headers:        config.Headers,
+		ExpectedStatus: config.ExpectedStatus,
+	}
+	if n.Method == "" {
+		n.Method = DefaultWebhookMethod
+	}
+	if config.Body != "" {
+		body, err := template.New("webhook").Funcs(dataFuncMap).Parse(config.Body)
+		if err != nil {
+			return nil, fmt.Errorf("incorrect webhook body template: %v", err)
+		}
+		n.Body = body
+	}
+	if config.Socket != "" {
+		n.Client = unixSocketClient(config.Socket)
+		if n.URL == "" {
+			n.URL = webhookSocketURL
+		}
+	}
+	if n.URL == "" {
+		return nil, fmt.Errorf("url or socket needed for webhook")
+	}
+	return n, nil
+}
+
+// unixSocketClient returns an HTTP client that sends all its requests
+// to a Unix socket. Connections are not kept alive, as clients are created
+// for each notification and they would be left open.
+func unixSocketClient(socket string) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+func (n *WebhookNotifier) body(notification *Notification) ([]byte, error) {
+	if n.Body == nil {
+		return json.Marshal(notification)
+	}
+	var b bytes.Buffer
+	err := n.Body.Execute(&b, notification)
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func (n *WebhookNotifier) expected(status int) bool {
+	if len(n.ExpectedStatus) == 0 {
+		return status >= 200 && status < 300
+	}
+	for _, expected := range n.ExpectedStatus {
+		if status == expected {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *WebhookNotifier) Run(ctx context.Context, notification *Notification) (string, error) {
+	body, err := n.body(notification)
+	if err != nil {
+		return "", fmt.Errorf("couldn't build webhook body: %v", err)
+	}
+	return n.send(ctx, body)
+}
+
+func (n *WebhookNotifier) send(ctx context.Context, body []byte) (string, error) {
+	req, err := http.NewRequest(n.Method, n.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	if n.Body == nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for name, value := range n.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	d, _ := ioutil.ReadAll(&io.LimitedReader{R: resp.Body, N: webhookMaxOutput})
+	if !n.expected(resp.StatusCode) {
+		return string(d), fmt.Errorf("unexpected status from %s: %s", n.URL, resp.Status)
+	}
+	return string(d), nil
+}
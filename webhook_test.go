@@ -0,0 +1,135 @@
+/*
+Copyright 2018 Tuenti Technologies S.L. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pouch
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testNotification = &Notification{
+	Notifier: "webhook",
+	Files:    []string{"/tmp/a", "/tmp/b"},
+	Secrets:  []string{"foo"},
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	requests := 0
+	var received Notification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		err := json.NewDecoder(r.Body).Decode(&received)
+		assert.NoError(t, err)
+	}))
+	defer server.Close()
+
+	p := NewPouch(nil, nil, nil, nil, nil).(*pouch)
+	runner, err := p.notifierRunner(NotifierConfig{Webhook: &WebhookConfig{
+		URL:     server.URL,
+		Headers: map[string]string{"X-Token": "secret"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = runner.Run(context.Background(), testNotification)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, *testNotification, received)
+
+	// Retries are done by the notifier, not by the webhook
+	_, err = runner.Run(context.Background(), testNotification)
+	assert.Error(t, err)
+	assert.Equal(t, 2, requests)
+}
+
+func TestWebhookNotifierSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pouch-test-webhook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "admin.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body, path string
+	var openConns int32
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d, _ := ioutil.ReadAll(r.Body)
+			body = string(d)
+			path = r.URL.Path
+			w.WriteHeader(http.StatusAccepted)
+		}),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			switch state {
+			case http.StateNew:
+				atomic.AddInt32(&openConns, 1)
+			case http.StateClosed, http.StateHijacked:
+				atomic.AddInt32(&openConns, -1)
+			}
+		},
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	runner, err := newWebhookNotifier(&WebhookConfig{
+		URL:            "http://localhost/reload",
+		Socket:         socket,
+		Body:           `{{ range .Files }}{{ . }} {{ end }}`,
+		ExpectedStatus: []int{http.StatusAccepted},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = runner.Run(context.Background(), testNotification)
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/a /tmp/b ", body)
+	assert.Equal(t, "/reload", path)
+
+	runner.ExpectedStatus = []int{http.StatusOK}
+	_, err = runner.Run(context.Background(), testNotification)
+	assert.Error(t, err, "Unexpected status should fail")
+
+	for i := 0; i < 100 && atomic.LoadInt32(&openConns) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&openConns), "Connections shouldn't be left open")
+
+	_, err = newWebhookNotifier(&WebhookConfig{})
+	assert.Error(t, err, "URL or socket should be required")
+}